@@ -2,6 +2,7 @@ package applier
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/go-logr/logr"
@@ -9,44 +10,150 @@ import (
 	"github.com/ocraviotto/pkg/client"
 	"github.com/ocraviotto/pkg/updater"
 	"github.com/ocraviotto/yaml-updater/pkg/config"
+	"github.com/ocraviotto/yaml-updater/pkg/names"
+	"github.com/ocraviotto/yaml-updater/pkg/retry"
+	"github.com/ocraviotto/yaml-updater/pkg/tracking"
 )
 
 // New creates and returns a new Applier.
 func New(l logr.Logger, c client.GitClient, cfgs *config.RepoConfiguration, opts ...updater.UpdaterFunc) *Applier {
-	return &Applier{configs: cfgs, log: l, updater: updater.New(l, c, opts...)}
+	return &Applier{configs: cfgs, log: l, client: c, updater: updater.New(l, c, opts...), updaterOpts: opts, randomNames: newRandomNames()}
 }
 
 // Applier can update a Git repo with an updated version of a file based on a
 // RepositoryPushHook.
 type Applier struct {
 	configs *config.RepoConfiguration
+	sources *config.RepoConfiguration
 	log     logr.Logger
+	client  client.GitClient
 	updater *updater.Updater
+
+	updaterOpts []updater.UpdaterFunc
+	clients     map[string]client.GitClient
+	finders     map[string]PullRequestFinder
+	finder      PullRequestFinder
+	randomNames names.UpdateGenerator
+	branchName  string
+	// reusable is true if branchName is not random, and so can be the name
+	// of a branch created by a previous run.
+	reusable bool
+	failFast bool
+	backoff  retry.Backoff
+	results  []Result
 }
 
 // UpdateRepositories takes a list of repositories (e.g. from config)
-// and for each it calls UpdateRepository, returning on any detected error.
+// and for each it calls UpdateRepository, in the order given by
+// RepoConfiguration.Order. A repository is skipped if any in its dependsOn
+// failed or was skipped, and all the remaining ones after a failure with
+// FailFast. Once ctx is done, the remaining ones are not started.
+//
+// If any update fails, it returns an UpdateError naming every failed
+// repository. The result for each repository is available with Results.
 func (u *Applier) UpdateRepositories(ctx context.Context, newValue string) error {
-	var result error
-	for key, repo := range u.configs.Repositories {
+	u.results = nil
+	order, err := u.configs.Order()
+	if err != nil {
+		return err
+	}
+	var failure error
+	failed := map[string]bool{}
+	for _, key := range order {
+		repo := u.configs.Repositories[key]
 		if repo.Disabled {
 			continue
 		}
-		if res := u.UpdateRepository(ctx, repo, newValue); res != nil {
-			u.log.Error(result, "Failed to update repository file", "repositoryKey", key, "repository", repo.SourceRepo, "file", repo.FilePath)
-			result = res
+		if err := ctx.Err(); err != nil {
+			failure = err
+			u.results = append(u.results, Result{Key: key, Status: StatusNotStarted, Err: err})
+			continue
+		}
+		if failure != nil && u.failFast {
+			u.results = append(u.results, Result{Key: key, Status: StatusSkipped, Err: fmt.Errorf("not attempted after a previous failure")})
+			continue
 		}
+		if dep := failedDependency(repo, failed); dep != "" {
+			u.log.Info("Skipping repository as a dependency failed", "repositoryKey", key, "dependsOn", dep)
+			failed[key] = true
+			u.results = append(u.results, Result{Key: key, Status: StatusSkipped, Err: fmt.Errorf("dependency %s failed", dep)})
+			continue
+		}
+		status, err := u.updateRepository(ctx, key, repo, newValue)
+		if err != nil {
+			u.log.Error(err, "Failed to update repository file", "repositoryKey", key, "repository", repo.SourceRepo, "file", repo.FilePath)
+			failed[key] = true
+			failure = err
+		}
+		u.results = append(u.results, Result{Key: key, Status: status, Err: err})
 	}
-	return result
+	if failure != nil {
+		return &UpdateError{Results: u.Results()}
+	}
+	return nil
+}
+
+// Results returns the result for each repository that the last call to
+// UpdateRepositories went through, in order.
+func (u *Applier) Results() []Result {
+	return append([]Result(nil), u.results...)
+}
+
+// failedDependency returns the first key in the dependsOn of repo that is in
+// failed, or an empty string.
+func failedDependency(repo *config.Repository, failed map[string]bool) string {
+	for _, dep := range repo.DependsOn {
+		if failed[dep] {
+			return dep
+		}
+	}
+	return ""
 }
 
 // UpdateRepository does the job of fetching the existing file, optionally creating it if it does not exist,
-// updating it, and then optionally creating a PR. It also supports file removal.
+// updating it, and then optionally creating a PR. It also supports file removal, and copying or moving
+// a file within the repository.
+//
+// When the file already has the update applied, nothing is written and it
+// succeeds.
 func (u *Applier) UpdateRepository(ctx context.Context, cfg *config.Repository, newValue string) error {
+	_, err := u.updateRepository(ctx, "", cfg, newValue)
+	return err
+}
+
+// UpdateRepositoryByKey is like UpdateRepository for the repository with key
+// in the configuration of the Applier, whose PR is marked with key as those of
+// UpdateRepositories are, and whose branch can be named after it.
+func (u *Applier) UpdateRepositoryByKey(ctx context.Context, key, newValue string) error {
+	var cfg *config.Repository
+	if u.configs != nil {
+		cfg = u.configs.Find(key)
+	}
+	if cfg == nil {
+		return fmt.Errorf("repository %s does not exist in the current repositories config", key)
+	}
+	_, err := u.updateRepository(ctx, key, cfg, newValue)
+	return err
+}
+
+func (u *Applier) updateRepository(ctx context.Context, key string, cfg *config.Repository, newValue string) (Status, error) {
 	var signature scm.Signature
+	newValue, err := u.valueFor(ctx, cfg, newValue)
+	if err != nil {
+		return StatusFailed, err
+	}
+	u, err = u.connection(cfg.Connection)
+	if err != nil {
+		return StatusFailed, err
+	}
 	cuFunc := updater.UpdateYAML(cfg.UpdateKey, newValue)
 	if cfg.RemoveKey {
 		cuFunc = updater.RemoveYAMLKey(cfg.UpdateKey)
+	} else if cfg.UpdateKey == "" && (cfg.CopyFrom != "" || cfg.MoveTo != "") {
+		cuFunc = keepContents
+	}
+	if cfg.CreateMissing && !cfg.RemoveFile && (cfg.Template != "" || cfg.TemplateFile != "") {
+		cuFunc = u.seedFromTemplate(ctx, cfg, templateData{Key: key, Name: cfg.Name, Value: newValue}, cuFunc)
 	}
 	cs := cfg.Signature
 	if cs != nil && cs.Name != "" && cs.Email != "" {
@@ -68,21 +175,67 @@ func (u *Applier) UpdateRepository(ctx context.Context, cfg *config.Repository,
 		CommitMessage:      commitMsg,
 		Signature:          signature,
 	}
-	newBranch, err := u.updater.ApplyUpdateToFile(ctx, ci, cuFunc)
+	u, err = u.withBranchName(key, cfg, newValue)
+	if err != nil {
+		return StatusFailed, err
+	}
+	var reused bool
+	if u.reusable {
+		u, ci, reused, err = u.reuseBranch(ctx, ci)
+		if err != nil {
+			return StatusFailed, err
+		}
+	}
+	if reused {
+		// The branch is not checked until the source branch is, which may
+		// have the update already.
+		if u.unchangedInSource(ctx, cfg, cuFunc) {
+			u.log.Info("file is unchanged in the source branch, skipping the update", "file", cfg.FilePath, "value", newValue)
+			return StatusUnchanged, nil
+		}
+		u.log.Info("reusing the existing branch", "branch", ci.Branch)
+	}
+	newBranch, retried, err := u.applyFileOperation(ctx, cfg, ci, cuFunc)
+	status := StatusUpdated
+	if errors.Is(err, errUnchanged) {
+		u.log.Info("file is unchanged, skipping the update", "file", cfg.FilePath, "value", newValue)
+		if !reused && !retried {
+			return StatusUnchanged, nil
+		}
+		// A previous run, or attempt, may have pushed the branch but not
+		// proposed it.
+		newBranch, status, err = u.branchName, StatusUnchanged, nil
+	}
 	if err != nil {
 		u.log.Error(err, "failed to get file from repo")
-		return err
+		return StatusFailed, err
+	}
+	if status == StatusUpdated {
+		u.log.Info("updated branch with value", "value", newValue, "branch", newBranch)
 	}
-	u.log.Info("updated branch with value", "value", newValue, "branch", newBranch)
 
 	// If we modified the original branch...
 	if newBranch == cfg.SourceBranch {
-		return nil
+		return status, nil
+	}
+	// ...or one already proposed by a previous run, and still open.
+	if reused {
+		proposed, err := u.hasOpenPullRequest(ctx, cfg.SourceRepo, newBranch)
+		if err != nil {
+			return StatusFailed, err
+		}
+		if proposed {
+			return status, nil
+		}
 	}
 
+	body := fmt.Sprintf("Automated update from %q", cfg.Name)
+	if key != "" {
+		body += "\n\n" + tracking.Marker(key)
+	}
 	pullRequestInput := updater.PullRequestInput{
 		Title:        fmt.Sprintf("Automated PR for yaml update from %q", cfg.Name),
-		Body:         fmt.Sprintf("Automated update from %q", cfg.Name),
+		Body:         body,
 		Repo:         cfg.SourceRepo,
 		NewBranch:    newBranch,
 		SourceBranch: cfg.SourceBranch,
@@ -90,8 +243,26 @@ func (u *Applier) UpdateRepository(ctx context.Context, cfg *config.Repository,
 
 	pr, err := u.updater.CreatePR(ctx, pullRequestInput)
 	if err != nil {
-		return fmt.Errorf("failed to create pull request in repo %s: %w", cfg.SourceRepo, err)
+		return StatusFailed, fmt.Errorf("failed to create pull request in repo %s: %w", cfg.SourceRepo, err)
 	}
 	u.log.Info("created PullRequest", "link", pr.Link)
-	return nil
+	return StatusUpdated, nil
+}
+
+// hasOpenPullRequest returns true if branch has an open pull request, or no
+// PullRequestFinder is set to tell.
+func (u *Applier) hasOpenPullRequest(ctx context.Context, repo, branch string) (bool, error) {
+	if u.finder == nil {
+		return true, nil
+	}
+	pr, err := u.finder.FindOpenPullRequest(ctx, repo, branch)
+	if err != nil {
+		return false, fmt.Errorf("failed to find the pull request of branch %s in repo %s: %w", branch, repo, err)
+	}
+	if pr == nil {
+		u.log.Info("branch has no open pull request", "branch", branch)
+		return false, nil
+	}
+	u.log.Info("branch already has a pull request", "link", pr.Link)
+	return true, nil
 }
@@ -4,14 +4,22 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
 	"testing"
+	"time"
 
 	"github.com/go-logr/zapr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/ocraviotto/go-scm/scm"
 	pkgClient "github.com/ocraviotto/pkg/client"
 	"github.com/ocraviotto/pkg/client/mock"
-	"github.com/ocraviotto/pkg/updater"
 	"github.com/ocraviotto/yaml-updater/pkg/config"
+	"github.com/ocraviotto/yaml-updater/pkg/names"
+	"github.com/ocraviotto/yaml-updater/pkg/retry"
+	"github.com/ocraviotto/yaml-updater/test"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zaptest"
 )
@@ -43,7 +51,7 @@ func TestUpdaterWithSingleRepo(t *testing.T) {
 	m.AssertBranchCreated(testGitHubRepo, "test-branch-a", testSHA)
 	m.AssertPullRequestCreated(testGitHubRepo, &scm.PullRequestInput{
 		Title:  fmt.Sprintf("Automated PR for yaml update from %q", testQuayRepo),
-		Body:   fmt.Sprintf("Automated update from %q", testQuayRepo),
+		Body:   fmt.Sprintf("Automated update from %q\n\n<!-- yaml-updater:key=testRepo -->", testQuayRepo),
 		Source: "test-branch-a",
 		Target: "master",
 	})
@@ -77,6 +85,34 @@ func TestUpdaterWithSingleRepositoryMethod(t *testing.T) {
 	})
 }
 
+func TestUpdaterWithRepositoryByKeyMethod(t *testing.T) {
+	testSHA := "980a0d5f19a64b4b30a87d4206aade58726b60e3"
+	m := mock.New(t)
+	m.AddFileContents(testGitHubRepo, testFilePath, "master", []byte("test:\n  image: old-image\n"))
+	m.AddBranchHead(testGitHubRepo, "master", testSHA)
+	configs := createConfigs()
+	configs.Repositories["testRepo"].BranchTemplate = "gitops/{{.Key}}"
+	applier := makeApplier(t, m, configs)
+
+	err := applier.UpdateRepositoryByKey(context.Background(), "testRepo", "repo:production")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.AssertBranchCreated(testGitHubRepo, "gitops/testRepo", testSHA)
+	m.AssertPullRequestCreated(testGitHubRepo, &scm.PullRequestInput{
+		Title:  fmt.Sprintf("Automated PR for yaml update from %q", testQuayRepo),
+		Body:   fmt.Sprintf("Automated update from %q\n\n<!-- yaml-updater:key=testRepo -->", testQuayRepo),
+		Source: "gitops/testRepo",
+		Target: "master",
+	})
+
+	err = applier.UpdateRepositoryByKey(context.Background(), "unknown", "repo:production")
+	if !test.MatchError(t, "repository unknown does not exist in the current repositories config", err) {
+		t.Fatalf("got error %v", err)
+	}
+}
+
 func TestUpdaterWithMultiRepo(t *testing.T) {
 	testSHA := "980a0d5f19a64b4b30a87d4206aade58726b60e3"
 	anotherTestSHA := "ab40b7377b39a4f876e7f49639b580a80b66e8ad"
@@ -113,13 +149,13 @@ func TestUpdaterWithMultiRepo(t *testing.T) {
 	m.AssertBranchCreated(secondRepo, "test-branch-a", anotherTestSHA)
 	m.AssertPullRequestCreated(testGitHubRepo, &scm.PullRequestInput{
 		Title:  fmt.Sprintf("Automated PR for yaml update from %q", testQuayRepo),
-		Body:   fmt.Sprintf("Automated update from %q", testQuayRepo),
+		Body:   fmt.Sprintf("Automated update from %q\n\n<!-- yaml-updater:key=testRepo -->", testQuayRepo),
 		Source: "test-branch-a",
 		Target: "master",
 	})
 	m.AssertPullRequestCreated(secondRepo, &scm.PullRequestInput{
 		Title:  fmt.Sprintf("Automated PR for yaml update from %q", testQuayRepo),
-		Body:   fmt.Sprintf("Automated update from %q", testQuayRepo),
+		Body:   fmt.Sprintf("Automated update from %q\n\n<!-- yaml-updater:key=testRepo2 -->", testQuayRepo),
 		Source: "test-branch-a",
 		Target: secondRepoBranch,
 	})
@@ -154,7 +190,7 @@ func TestUpdaterWithNoNameGenerator(t *testing.T) {
 	m.AssertBranchCreated(testGitHubRepo, "a", testSHA)
 	m.AssertPullRequestCreated(testGitHubRepo, &scm.PullRequestInput{
 		Title:  fmt.Sprintf("Automated PR for yaml update from %q", testQuayRepo),
-		Body:   fmt.Sprintf("Automated update from %q", testQuayRepo),
+		Body:   fmt.Sprintf("Automated update from %q\n\n<!-- yaml-updater:key=testRepo -->", testQuayRepo),
 		Source: "a",
 		Target: sourceBranch,
 	})
@@ -198,7 +234,7 @@ func TestUpdaterWithMissingFile(t *testing.T) {
 
 	err := applier.UpdateRepositories(context.Background(), newValue)
 
-	if err != testErr {
+	if !errors.Is(err, testErr) {
 		t.Fatalf("got %s, want %s", err, testErr)
 	}
 	updated := m.GetUpdatedContents(testGitHubRepo, testFilePath, "test-branch-a")
@@ -236,6 +272,785 @@ func TestUpdaterWithCreateMissingFile(t *testing.T) {
 	}
 }
 
+func TestUpdaterWithCreateMissingFromTemplate(t *testing.T) {
+	testSHA := "980a0d5f19a64b4b30a87d4206aade58726b60e3"
+	templateFile := filepath.Join(t.TempDir(), "template.yaml")
+	if err := ioutil.WriteFile(templateFile, []byte("service: {{ .Key }}\ntest:\n  name: {{ .Name }}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	templateTests := []struct {
+		name         string
+		template     string
+		templateFile string
+	}{
+		{"inline template", "service: {{ .Key }}\ntest:\n  name: {{ .Name }}\n", ""},
+		{"local template file", "", templateFile},
+	}
+
+	for _, tt := range templateTests {
+		t.Run(tt.name, func(rt *testing.T) {
+			m := mock.New(rt)
+			m.AddBranchHead(testGitHubRepo, "master", testSHA)
+			m.GetFileErr = pkgClient.SCMError{Msg: "not found", Status: 404}
+			configs := createConfigs()
+			configs.Repositories["testRepo"].CreateMissing = true
+			configs.Repositories["testRepo"].Template = tt.template
+			configs.Repositories["testRepo"].TemplateFile = tt.templateFile
+			applier := makeApplier(rt, m, configs)
+			newValue := "repo:production"
+
+			err := applier.UpdateRepositories(context.Background(), newValue)
+			if err != nil {
+				rt.Fatal(err)
+			}
+			updated := m.GetUpdatedContents(testGitHubRepo, testFilePath, "test-branch-a")
+			want := fmt.Sprintf("service: testRepo\ntest:\n  image: %s\n  name: %s\n", newValue, testQuayRepo)
+			if s := string(updated); s != want {
+				rt.Fatalf("update failed, got %#v, want %#v", s, want)
+			}
+		})
+	}
+}
+
+func TestUpdaterWithTemplateForExistingFile(t *testing.T) {
+	m := mock.New(t)
+	m.AddFileContents(testGitHubRepo, testFilePath, "master", []byte("test:\n  image: old-image\n"))
+	m.AddBranchHead(testGitHubRepo, "master", "980a0d5f19a64b4b30a87d4206aade58726b60e3")
+	configs := createConfigs()
+	configs.Repositories["testRepo"].CreateMissing = true
+	// The template is not loaded for a file that exists.
+	configs.Repositories["testRepo"].TemplateFile = "repo:templates/missing.yaml"
+	applier := makeApplier(t, m, configs)
+
+	err := applier.UpdateRepositories(context.Background(), "new-image")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "test:\n  image: new-image\n"
+	if s := string(m.GetUpdatedContents(testGitHubRepo, testFilePath, "test-branch-a")); s != want {
+		t.Fatalf("update failed, got %#v, want %#v", s, want)
+	}
+}
+
+func TestUpdaterWithCopyFrom(t *testing.T) {
+	testSHA := "980a0d5f19a64b4b30a87d4206aade58726b60e3"
+	stagingPath := "environments/staging/services/service-a/test.yaml"
+	m := newFileOpsClient(t)
+	m.AddFileContents(testGitHubRepo, stagingPath, "master", []byte("test:\n  image: staging-image\n  replicas: 2\n"))
+	m.AddBranchHead(testGitHubRepo, "master", testSHA)
+	configs := createConfigs()
+	configs.Repositories["testRepo"].CopyFrom = stagingPath
+	applier := makeApplier(t, m, configs)
+	newValue := "repo:production"
+
+	err := applier.UpdateRepositories(context.Background(), newValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updated := m.GetUpdatedContents(testGitHubRepo, testFilePath, "test-branch-a")
+	want := fmt.Sprintf("test:\n  image: %s\n  replicas: 2\n", newValue)
+	if s := string(updated); s != want {
+		t.Fatalf("update failed, got %#v, want %#v", s, want)
+	}
+	if len(m.deleted) != 0 {
+		t.Fatalf("copying should not delete files, got %v", m.deleted)
+	}
+	m.AssertBranchCreated(testGitHubRepo, "test-branch-a", testSHA)
+}
+
+func TestUpdaterWithPlainCopyFrom(t *testing.T) {
+	testSHA := "980a0d5f19a64b4b30a87d4206aade58726b60e3"
+	stagingPath := "environments/staging/services/service-a/test.yaml"
+	m := newFileOpsClient(t)
+	m.AddFileContents(testGitHubRepo, stagingPath, "master", []byte("test:\n  image: staging-image\n"))
+	m.AddBranchHead(testGitHubRepo, "master", testSHA)
+	configs := createConfigs()
+	configs.Repositories["testRepo"].CopyFrom = stagingPath
+	configs.Repositories["testRepo"].UpdateKey = ""
+	applier := makeApplier(t, m, configs)
+
+	err := applier.UpdateRepositories(context.Background(), "repo:production")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "test:\n  image: staging-image\n"
+	if s := string(m.GetUpdatedContents(testGitHubRepo, testFilePath, "test-branch-a")); s != want {
+		t.Fatalf("update failed, got %#v, want %#v", s, want)
+	}
+	m.AssertBranchCreated(testGitHubRepo, "test-branch-a", testSHA)
+	if diff := cmp.Diff([]Result{{Key: "testRepo", Status: StatusUpdated}}, applier.Results()); diff != "" {
+		t.Errorf("Results() failed diff\n%s", diff)
+	}
+}
+
+func TestUpdaterWithCopyFromSourceHavingValue(t *testing.T) {
+	testSHA := "980a0d5f19a64b4b30a87d4206aade58726b60e3"
+	stagingPath := "environments/staging/services/service-a/test.yaml"
+	m := newFileOpsClient(t)
+	m.AddFileContents(testGitHubRepo, stagingPath, "master", []byte("test:\n  image: repo:production\n"))
+	m.AddFileContents(testGitHubRepo, testFilePath, "master", []byte("test:\n  image: old-image\n"))
+	m.AddBranchHead(testGitHubRepo, "master", testSHA)
+	configs := createConfigs()
+	configs.Repositories["testRepo"].CopyFrom = stagingPath
+	applier := makeApplier(t, m, configs)
+
+	err := applier.UpdateRepositories(context.Background(), "repo:production")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "test:\n  image: repo:production\n"
+	if s := string(m.GetUpdatedContents(testGitHubRepo, testFilePath, "test-branch-a")); s != want {
+		t.Fatalf("update failed, got %#v, want %#v", s, want)
+	}
+	m.AssertBranchCreated(testGitHubRepo, "test-branch-a", testSHA)
+	if diff := cmp.Diff([]Result{{Key: "testRepo", Status: StatusUpdated}}, applier.Results()); diff != "" {
+		t.Errorf("Results() failed diff\n%s", diff)
+	}
+}
+
+func TestUpdaterWithMoveTo(t *testing.T) {
+	testSHA := "980a0d5f19a64b4b30a87d4206aade58726b60e3"
+	prodPath := "environments/prod/services/service-a/test.yaml"
+	m := newFileOpsClient(t)
+	m.AddFileContents(testGitHubRepo, testFilePath, "master", []byte("test:\n  image: old-image\n"))
+	m.AddFileContents(testGitHubRepo, testFilePath, "test-branch-a", []byte("test:\n  image: old-image\n"))
+	m.AddBranchHead(testGitHubRepo, "master", testSHA)
+	m.AddBranchHead(testGitHubRepo, "test-branch-a", testSHA)
+	configs := createConfigs()
+	configs.Repositories["testRepo"].MoveTo = prodPath
+	applier := makeApplier(t, m, configs)
+	newValue := "repo:production"
+
+	err := applier.UpdateRepositories(context.Background(), newValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updated := m.GetUpdatedContents(testGitHubRepo, prodPath, "test-branch-a")
+	want := fmt.Sprintf("test:\n  image: %s\n", newValue)
+	if s := string(updated); s != want {
+		t.Fatalf("update failed, got %#v, want %#v", s, want)
+	}
+	wantDeleted := []string{fmt.Sprintf("%s:%s:%s", testGitHubRepo, testFilePath, "test-branch-a")}
+	if diff := cmp.Diff(wantDeleted, m.deleted); diff != "" {
+		t.Fatalf("deleted files failed diff\n%s", diff)
+	}
+	m.AssertBranchCreated(testGitHubRepo, "test-branch-a", testSHA)
+	m.AssertPullRequestCreated(testGitHubRepo, &scm.PullRequestInput{
+		Title:  fmt.Sprintf("Automated PR for yaml update from %q", testQuayRepo),
+		Body:   fmt.Sprintf("Automated update from %q\n\n<!-- yaml-updater:key=testRepo -->", testQuayRepo),
+		Source: "test-branch-a",
+		Target: "master",
+	})
+}
+
+func TestUpdaterWithValueFrom(t *testing.T) {
+	testSHA := "980a0d5f19a64b4b30a87d4206aade58726b60e3"
+	stagingPath := "environments/staging/services/service-a/test.yaml"
+	m := mock.New(t)
+	m.AddFileContents(testGitHubRepo, testFilePath, "master", []byte("test:\n  image: old-image\n"))
+	m.AddFileContents(testGitHubRepo, stagingPath, "master", []byte("test:\n  image: staging-image\n"))
+	m.AddBranchHead(testGitHubRepo, "master", testSHA)
+	configs := createConfigs()
+	configs.Repositories["testRepo"].ValueFrom = "staging"
+	sources := createConfigs()
+	sources.Repositories["staging"] = &config.Repository{
+		SourceRepo:   testGitHubRepo,
+		SourceBranch: "master",
+		FilePath:     stagingPath,
+		UpdateKey:    "test.image",
+	}
+	applier := makeApplier(t, m, configs).With(ValueSources(sources))
+
+	err := applier.UpdateRepositories(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updated := m.GetUpdatedContents(testGitHubRepo, testFilePath, "test-branch-a")
+	want := "test:\n  image: staging-image\n"
+	if s := string(updated); s != want {
+		t.Fatalf("update failed, got %#v, want %#v", s, want)
+	}
+}
+
+func TestUpdaterWithValue(t *testing.T) {
+	testSHA := "980a0d5f19a64b4b30a87d4206aade58726b60e3"
+	m := mock.New(t)
+	m.AddFileContents(testGitHubRepo, testFilePath, "master", []byte("test:\n  image: old-image\n"))
+	m.AddBranchHead(testGitHubRepo, "master", testSHA)
+	configs := createConfigs()
+	configs.Repositories["testRepo"].Value = "entry-image"
+	applier := makeApplier(t, m, configs)
+
+	err := applier.UpdateRepositories(context.Background(), "flag-image")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updated := m.GetUpdatedContents(testGitHubRepo, testFilePath, "test-branch-a")
+	want := "test:\n  image: entry-image\n"
+	if s := string(updated); s != want {
+		t.Fatalf("update failed, got %#v, want %#v", s, want)
+	}
+}
+
+func TestUpdaterWithConnection(t *testing.T) {
+	testSHA := "980a0d5f19a64b4b30a87d4206aade58726b60e3"
+	m := mock.New(t)
+	internal := mock.New(t)
+	internal.AddFileContents(testGitHubRepo, testFilePath, "master", []byte("test:\n  image: old-image\n"))
+	internal.AddBranchHead(testGitHubRepo, "master", testSHA)
+	configs := createConfigs()
+	configs.Repositories["testRepo"].Connection = "internal"
+	applier := makeApplier(t, m, configs).With(Connections(map[string]pkgClient.GitClient{"internal": internal}))
+
+	err := applier.UpdateRepositories(context.Background(), "repo:production")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updated := internal.GetUpdatedContents(testGitHubRepo, testFilePath, "test-branch-a")
+	want := "test:\n  image: repo:production\n"
+	if s := string(updated); s != want {
+		t.Fatalf("update failed, got %#v, want %#v", s, want)
+	}
+	internal.AssertPullRequestCreated(testGitHubRepo, &scm.PullRequestInput{
+		Title:  fmt.Sprintf("Automated PR for yaml update from %q", testQuayRepo),
+		Body:   fmt.Sprintf("Automated update from %q\n\n<!-- yaml-updater:key=testRepo -->", testQuayRepo),
+		Source: "test-branch-a",
+		Target: "master",
+	})
+}
+
+func TestUpdaterWithUnknownConnection(t *testing.T) {
+	m := mock.New(t)
+	configs := createConfigs()
+	configs.Repositories["testRepo"].Connection = "internal"
+	applier := makeApplier(t, m, configs)
+
+	err := applier.UpdateRepositories(context.Background(), "repo:production")
+
+	if !test.MatchError(t, "failed to update repository testRepo: connection internal is not configured", err) {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+func TestUpdaterWithBranchTemplate(t *testing.T) {
+	testSHA := "980a0d5f19a64b4b30a87d4206aade58726b60e3"
+	m := mock.New(t)
+	m.AddFileContents(testGitHubRepo, testFilePath, "master", []byte("test:\n  image: old-image\n"))
+	m.AddBranchHead(testGitHubRepo, "master", testSHA)
+	configs := createConfigs()
+	configs.Repositories["testRepo"].BranchTemplate = "gitops/{{.Key}}/{{.Value | slug}}"
+	applier := makeApplier(t, m, configs)
+
+	err := applier.UpdateRepositories(context.Background(), "quay.io/my-org/my-image:v1.1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "test:\n  image: quay.io/my-org/my-image:v1.1.0\n"
+	if s := string(m.GetUpdatedContents(testGitHubRepo, testFilePath, "gitops/testRepo/quay-io-my-org-my-image-v1-1-0")); s != want {
+		t.Fatalf("update failed, got %#v, want %#v", s, want)
+	}
+	m.AssertBranchCreated(testGitHubRepo, "gitops/testRepo/quay-io-my-org-my-image-v1-1-0", testSHA)
+	m.AssertPullRequestCreated(testGitHubRepo, &scm.PullRequestInput{
+		Title:  fmt.Sprintf("Automated PR for yaml update from %q", testQuayRepo),
+		Body:   fmt.Sprintf("Automated update from %q\n\n<!-- yaml-updater:key=testRepo -->", testQuayRepo),
+		Source: "gitops/testRepo/quay-io-my-org-my-image-v1-1-0",
+		Target: "master",
+	})
+}
+
+func TestUpdaterReusesHashedBranch(t *testing.T) {
+	testSHA := "980a0d5f19a64b4b30a87d4206aade58726b60e3"
+	newValue := "repo:production"
+	branch, err := names.Hash{}.UpdateName(names.Update{Prefix: "test-branch-", Key: "testRepo", Value: newValue})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mock.New(t)
+	m.AddFileContents(testGitHubRepo, testFilePath, "master", []byte("test:\n  image: old-image\n"))
+	m.AddBranchHead(testGitHubRepo, "master", testSHA)
+	// A previous run left the branch with another change.
+	m.AddFileContents(testGitHubRepo, testFilePath, branch, []byte("test:\n  image: old-image\n  replicas: 3\n"))
+	m.AddBranchHead(testGitHubRepo, branch, "ab40b7377b39a4f876e7f49639b580a80b66e8ad")
+	configs := createConfigs()
+	configs.Repositories["testRepo"].BranchNaming = "hash"
+	finder := stubFinder{branch: {Number: 1, Source: branch}}
+	applier := makeApplier(t, m, configs).With(PullRequestFinders(map[string]PullRequestFinder{"": finder}))
+
+	err = applier.UpdateRepositories(context.Background(), newValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := fmt.Sprintf("test:\n  image: %s\n  replicas: 3\n", newValue)
+	if s := string(m.GetUpdatedContents(testGitHubRepo, testFilePath, branch)); s != want {
+		t.Fatalf("update failed, got %#v, want %#v", s, want)
+	}
+	m.AssertNoBranchesCreated()
+	m.AssertNoPullRequestsCreated()
+	if diff := cmp.Diff([]Result{{Key: "testRepo", Status: StatusUpdated}}, applier.Results(), cmpopts.IgnoreFields(Result{}, "Err")); diff != "" {
+		t.Errorf("Results() failed diff\n%s", diff)
+	}
+}
+
+func TestUpdaterProposesReusedBranch(t *testing.T) {
+	testSHA := "980a0d5f19a64b4b30a87d4206aade58726b60e3"
+	newValue := "repo:production"
+	stale, err := names.Hash{}.UpdateName(names.Update{Prefix: "test-branch-", Key: "testRepo", Value: newValue})
+	if err != nil {
+		t.Fatal(err)
+	}
+	branch := stale + "-980a0d5"
+	reuseTests := []struct {
+		name       string
+		contents   string
+		wantStatus Status
+	}{
+		{"updated", "test:\n  image: old-image\n", StatusUpdated},
+		{"unchanged", fmt.Sprintf("test:\n  image: %s\n", newValue), StatusUpdated},
+	}
+
+	for _, tt := range reuseTests {
+		t.Run(tt.name, func(rt *testing.T) {
+			m := mock.New(rt)
+			m.AddFileContents(testGitHubRepo, testFilePath, "master", []byte("test:\n  image: old-image\n"))
+			m.AddBranchHead(testGitHubRepo, "master", testSHA)
+			m.AddBranchHead(testGitHubRepo, stale, "ab40b7377b39a4f876e7f49639b580a80b66e8ad")
+			// A previous run for the same source head pushed the branch, but
+			// its PR was closed or never created.
+			m.AddFileContents(testGitHubRepo, testFilePath, branch, []byte(tt.contents))
+			m.AddBranchHead(testGitHubRepo, branch, "c6e6d2b0d7b0a4c6a0f0e4b5a7d8c9e0f1a2b3c4")
+			configs := createConfigs()
+			configs.Repositories["testRepo"].BranchNaming = "hash"
+			applier := makeApplier(rt, m, configs).With(PullRequestFinders(map[string]PullRequestFinder{"": stubFinder{}}))
+
+			err = applier.UpdateRepositories(context.Background(), newValue)
+			if err != nil {
+				rt.Fatal(err)
+			}
+
+			m.AssertNoBranchesCreated()
+			m.AssertPullRequestCreated(testGitHubRepo, &scm.PullRequestInput{
+				Title:  fmt.Sprintf("Automated PR for yaml update from %q", testQuayRepo),
+				Body:   fmt.Sprintf("Automated update from %q\n\n<!-- yaml-updater:key=testRepo -->", testQuayRepo),
+				Source: branch,
+				Target: "master",
+			})
+			if diff := cmp.Diff([]Result{{Key: "testRepo", Status: tt.wantStatus}}, applier.Results()); diff != "" {
+				rt.Errorf("Results() failed diff\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUpdaterWithStaleBranch(t *testing.T) {
+	testSHA := "980a0d5f19a64b4b30a87d4206aade58726b60e3"
+	newValue := "repo:production"
+	stale, err := names.Hash{}.UpdateName(names.Update{Prefix: "test-branch-", Key: "testRepo", Value: newValue})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newClient := func(t *testing.T, source string) *mock.MockClient {
+		m := mock.New(t)
+		m.AddFileContents(testGitHubRepo, testFilePath, "master", []byte(source))
+		m.AddBranchHead(testGitHubRepo, "master", testSHA)
+		// The PR of a previous run for the value was merged, and its branch
+		// was not deleted.
+		m.AddFileContents(testGitHubRepo, testFilePath, stale, []byte("test:\n  image: old-image\n"))
+		m.AddBranchHead(testGitHubRepo, stale, "ab40b7377b39a4f876e7f49639b580a80b66e8ad")
+		return m
+	}
+	configs := createConfigs()
+	configs.Repositories["testRepo"].BranchNaming = "hash"
+	finders := map[string]PullRequestFinder{"": stubFinder{}}
+
+	t.Run("source has the value", func(rt *testing.T) {
+		m := newClient(rt, fmt.Sprintf("test:\n  image: %s\n  replicas: 3\n", newValue))
+		applier := makeApplier(rt, m, configs).With(PullRequestFinders(finders))
+
+		if err := applier.UpdateRepositories(context.Background(), newValue); err != nil {
+			rt.Fatal(err)
+		}
+
+		m.AssertNoBranchesCreated()
+		m.AssertNoPullRequestsCreated()
+		if diff := cmp.Diff([]Result{{Key: "testRepo", Status: StatusUnchanged}}, applier.Results()); diff != "" {
+			rt.Errorf("Results() failed diff\n%s", diff)
+		}
+	})
+
+	t.Run("rolled back", func(rt *testing.T) {
+		m := newClient(rt, "test:\n  image: new-image\n  replicas: 3\n")
+		applier := makeApplier(rt, m, configs).With(PullRequestFinders(finders))
+
+		if err := applier.UpdateRepositories(context.Background(), newValue); err != nil {
+			rt.Fatal(err)
+		}
+
+		branch := stale + "-980a0d5"
+		m.AssertBranchCreated(testGitHubRepo, branch, testSHA)
+		want := fmt.Sprintf("test:\n  image: %s\n  replicas: 3\n", newValue)
+		if s := string(m.GetUpdatedContents(testGitHubRepo, testFilePath, branch)); s != want {
+			rt.Fatalf("update failed, got %#v, want %#v", s, want)
+		}
+		m.AssertPullRequestCreated(testGitHubRepo, &scm.PullRequestInput{
+			Title:  fmt.Sprintf("Automated PR for yaml update from %q", testQuayRepo),
+			Body:   fmt.Sprintf("Automated update from %q\n\n<!-- yaml-updater:key=testRepo -->", testQuayRepo),
+			Source: branch,
+			Target: "master",
+		})
+	})
+}
+
+func TestRandomBranchNames(t *testing.T) {
+	configs := createConfigs()
+	configs.Repositories["testRepo"].BranchGenerateName = "gitops:service a..v1-"
+	applier := New(zapr.NewLogger(zaptest.NewLogger(t, zaptest.Level(zap.WarnLevel))), mock.New(t), configs)
+
+	a, err := applier.withBranchName("testRepo", configs.Repositories["testRepo"], "repo:production")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !regexp.MustCompile(`^gitops-service-a-v1-[a-zA-Z]{5}$`).MatchString(a.branchName) {
+		t.Fatalf("got branch name %s, want a sanitized random name", a.branchName)
+	}
+	if a.reusable {
+		t.Fatal("random branch names should not be reused from previous runs")
+	}
+}
+
+func TestUpdaterWithUnknownBranchNaming(t *testing.T) {
+	m := mock.New(t)
+	configs := createConfigs()
+	configs.Repositories["testRepo"].BranchNaming = "sequential"
+	applier := makeApplier(t, m, configs)
+
+	err := applier.UpdateRepositories(context.Background(), "repo:production")
+
+	if !test.MatchError(t, "failed to update repository testRepo: unknown branchNaming sequential, must be random, hash, timestamp or template", err) {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+func TestUpdaterWithDependsOn(t *testing.T) {
+	testSHA := "980a0d5f19a64b4b30a87d4206aade58726b60e3"
+	m := mock.New(t)
+	for _, env := range []string{"staging", "prod", "other"} {
+		m.AddFileContents(testGitHubRepo, env+".yaml", "master", []byte("test:\n  image: old-image\n"))
+	}
+	m.AddBranchHead(testGitHubRepo, "master", testSHA)
+	configs := &config.RepoConfiguration{Repositories: map[string]*config.Repository{}}
+	for env, deps := range map[string][]string{"dev": nil, "staging": {"dev"}, "prod": {"staging"}, "other": nil} {
+		configs.Repositories[env] = &config.Repository{
+			SourceRepo:         testGitHubRepo,
+			SourceBranch:       "master",
+			FilePath:           env + ".yaml",
+			UpdateKey:          "test.image",
+			BranchGenerateName: "test-branch-",
+			DependsOn:          deps,
+		}
+	}
+	applier := makeApplier(t, m, configs)
+
+	err := applier.UpdateRepositories(context.Background(), "new-image")
+	if !test.MatchError(t, "not found", err) {
+		t.Fatalf("got error %v, want not found", err)
+	}
+
+	for _, env := range []string{"staging", "prod"} {
+		if updated := m.GetUpdatedContents(testGitHubRepo, env+".yaml", "test-branch-a"); updated != nil {
+			t.Fatalf("%s was updated after its dependency failed: %s", env, updated)
+		}
+	}
+	want := "test:\n  image: new-image\n"
+	if s := string(m.GetUpdatedContents(testGitHubRepo, "other.yaml", "test-branch-a")); s != want {
+		t.Fatalf("update failed, got %#v, want %#v", s, want)
+	}
+}
+
+func TestUpdaterFailurePolicy(t *testing.T) {
+	policyTests := []struct {
+		name        string
+		opts        []Option
+		want        []Status
+		wantErr     string
+		wantPartial bool
+	}{
+		{
+			"continue on error",
+			nil,
+			[]Status{StatusFailed, StatusUpdated},
+			"failed to update repository a-missing: not found$",
+			true,
+		},
+		{
+			"fail fast",
+			[]Option{FailFast()},
+			[]Status{StatusFailed, StatusSkipped},
+			`failed to update repository a-missing: not found \(skipped b-present\)`,
+			false,
+		},
+	}
+
+	for _, tt := range policyTests {
+		t.Run(tt.name, func(rt *testing.T) {
+			m := mock.New(rt)
+			m.AddFileContents(testGitHubRepo, "b-present.yaml", "master", []byte("test:\n  image: old-image\n"))
+			m.AddBranchHead(testGitHubRepo, "master", "980a0d5f19a64b4b30a87d4206aade58726b60e3")
+			configs := &config.RepoConfiguration{Repositories: map[string]*config.Repository{}}
+			for _, key := range []string{"a-missing", "b-present"} {
+				configs.Repositories[key] = &config.Repository{
+					SourceRepo:         testGitHubRepo,
+					SourceBranch:       "master",
+					FilePath:           key + ".yaml",
+					UpdateKey:          "test.image",
+					BranchGenerateName: "test-branch-",
+				}
+			}
+			applier := makeApplier(rt, m, configs).With(tt.opts...)
+
+			err := applier.UpdateRepositories(context.Background(), "new-image")
+			if !test.MatchError(rt, tt.wantErr, err) {
+				rt.Fatalf("got error %v, want %s", err, tt.wantErr)
+			}
+			var updateErr *UpdateError
+			if !errors.As(err, &updateErr) {
+				rt.Fatalf("got error %T, want an UpdateError", err)
+			}
+			if diff := cmp.Diff([]string{"a-missing"}, updateErr.Failed()); diff != "" {
+				rt.Errorf("Failed() failed diff\n%s", diff)
+			}
+			if updateErr.Partial() != tt.wantPartial {
+				rt.Errorf("Partial() got %v, want %v", updateErr.Partial(), tt.wantPartial)
+			}
+			var got []Status
+			for _, r := range applier.Results() {
+				got = append(got, r.Status)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				rt.Errorf("Results() failed diff\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUpdaterWithUnchangedValue(t *testing.T) {
+	contentTests := []struct {
+		name     string
+		contents string
+	}{
+		{"same content", "test:\n  image: repo:production\n"},
+		{"same yaml", "test: {image: \"repo:production\"}\n"},
+	}
+
+	for _, tt := range contentTests {
+		t.Run(tt.name, func(rt *testing.T) {
+			m := mock.New(rt)
+			m.AddFileContents(testGitHubRepo, testFilePath, "master", []byte(tt.contents))
+			m.AddBranchHead(testGitHubRepo, "master", "980a0d5f19a64b4b30a87d4206aade58726b60e3")
+			applier := makeApplier(rt, m, createConfigs())
+
+			err := applier.UpdateRepositories(context.Background(), "repo:production")
+			if err != nil {
+				rt.Fatal(err)
+			}
+
+			if updated := m.GetUpdatedContents(testGitHubRepo, testFilePath, "test-branch-a"); updated != nil {
+				rt.Fatalf("unchanged file was updated: %s", updated)
+			}
+			m.AssertNoBranchesCreated()
+			m.AssertNoPullRequestsCreated()
+			want := []Result{{Key: "testRepo", Status: StatusUnchanged}}
+			if diff := cmp.Diff(want, applier.Results()); diff != "" {
+				rt.Errorf("Results() failed diff\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSameYAML(t *testing.T) {
+	yamlTests := []struct {
+		a, b string
+		want bool
+	}{
+		{"a: 1\n", "a: 1\n", true},
+		{"a: {b: 1}\n", "a:\n  b: 1\n", true},
+		{"a: 1\n", "a: 2\n", false},
+		{"", "a: 1\n", false},
+		{"a: 1\n---\nb: 1\n", "a: 1\n---\nb: 2\n", false},
+	}
+
+	for _, tt := range yamlTests {
+		if got := sameYAML([]byte(tt.a), []byte(tt.b)); got != tt.want {
+			t.Errorf("sameYAML(%q, %q) got %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestUpdaterRetriesConflicts(t *testing.T) {
+	retryTests := []struct {
+		name      string
+		conflicts int
+		want      string
+		wantErr   string
+	}{
+		{"retried", 1, "test:\n  image: new-image\n  replicas: 3\n", ""},
+		{"attempts exhausted", 3, "", "failed to update file: .* \\(409\\)"},
+	}
+
+	for _, tt := range retryTests {
+		t.Run(tt.name, func(rt *testing.T) {
+			m := &conflictClient{MockClient: mock.New(rt), conflicts: tt.conflicts}
+			m.AddFileContents(testGitHubRepo, testFilePath, "master", []byte("test:\n  image: old-image\n"))
+			m.AddBranchHead(testGitHubRepo, "master", "980a0d5f19a64b4b30a87d4206aade58726b60e3")
+			configs := createConfigs()
+			configs.Repositories["testRepo"].DisablePRCreation = true
+			applier := New(zapr.NewLogger(zaptest.NewLogger(rt, zaptest.Level(zap.WarnLevel))), m, configs).
+				With(Retries(retry.Backoff{Attempts: 3, Delay: time.Millisecond}))
+
+			err := applier.UpdateRepository(context.Background(), configs.Repositories["testRepo"], "new-image")
+			if !test.MatchError(rt, tt.wantErr, err) {
+				rt.Fatalf("got error %v, want %s", err, tt.wantErr)
+			}
+
+			updated := m.GetUpdatedContents(testGitHubRepo, testFilePath, "master")
+			if s := string(updated); s != tt.want {
+				rt.Fatalf("update failed, got %#v, want %#v", s, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdaterRetriesConflictsInSameBranch(t *testing.T) {
+	m := &conflictClient{MockClient: mock.New(t), conflicts: 1}
+	m.AddFileContents(testGitHubRepo, testFilePath, "master", []byte("test:\n  image: old-image\n"))
+	m.AddBranchHead(testGitHubRepo, "master", "980a0d5f19a64b4b30a87d4206aade58726b60e3")
+	configs := createConfigs()
+	applier := New(zapr.NewLogger(zaptest.NewLogger(t, zaptest.Level(zap.WarnLevel))), m, configs).
+		With(Retries(retry.Backoff{Attempts: 3, Delay: time.Millisecond}))
+
+	err := applier.UpdateRepositories(context.Background(), "new-image")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.branches) != 1 {
+		t.Fatalf("got branches %v, want a single one", m.branches)
+	}
+	want := "test:\n  image: new-image\n  replicas: 3\n"
+	if s := string(m.GetUpdatedContents(testGitHubRepo, testFilePath, m.branches[0])); s != want {
+		t.Fatalf("update failed, got %#v, want %#v", s, want)
+	}
+}
+
+func TestUpdaterProposesBranchOfConflictingAttempt(t *testing.T) {
+	// The concurrent change to the branch created by the first attempt has
+	// the value already.
+	m := &conflictClient{MockClient: mock.New(t), conflicts: 1, concurrent: "test:\n  image: new-image\n"}
+	m.AddFileContents(testGitHubRepo, testFilePath, "master", []byte("test:\n  image: old-image\n"))
+	m.AddBranchHead(testGitHubRepo, "master", "980a0d5f19a64b4b30a87d4206aade58726b60e3")
+	applier := makeApplier(t, m, createConfigs()).With(Retries(retry.Backoff{Attempts: 3, Delay: time.Millisecond}))
+
+	err := applier.UpdateRepositories(context.Background(), "new-image")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]string{"test-branch-a"}, m.branches); diff != "" {
+		t.Fatalf("branches created failed diff\n%s", diff)
+	}
+	m.AssertPullRequestCreated(testGitHubRepo, &scm.PullRequestInput{
+		Title:  fmt.Sprintf("Automated PR for yaml update from %q", testQuayRepo),
+		Body:   fmt.Sprintf("Automated update from %q\n\n<!-- yaml-updater:key=testRepo -->", testQuayRepo),
+		Source: "test-branch-a",
+		Target: "master",
+	})
+	if diff := cmp.Diff([]Result{{Key: "testRepo", Status: StatusUpdated}}, applier.Results()); diff != "" {
+		t.Errorf("Results() failed diff\n%s", diff)
+	}
+}
+
+func TestIsConflict(t *testing.T) {
+	conflictTests := []struct {
+		err  error
+		want bool
+	}{
+		{pkgClient.SCMError{Msg: "failed to update file", Status: 409}, true},
+		{fmt.Errorf("failed to update file: %w", pkgClient.SCMError{Msg: "failed to update file: Invalid request.\n\n\"sha\" wasn't supplied.", Status: 422}), true},
+		{pkgClient.SCMError{Msg: "failed to update file: sha does not match", Status: 422}, true},
+		{pkgClient.SCMError{Msg: "failed to update file: Invalid request. Author email is invalid", Status: 422}, false},
+		{pkgClient.SCMError{Msg: "failed to update file", Status: 500}, false},
+		{errors.New("sha does not match"), false},
+	}
+
+	for _, tt := range conflictTests {
+		if got := isConflict(tt.err); got != tt.want {
+			t.Errorf("isConflict(%q) got %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestUpdaterInterrupted(t *testing.T) {
+	m := mock.New(t)
+	m.AddFileContents(testGitHubRepo, testFilePath, "master", []byte("test:\n  image: old-image\n"))
+	m.AddBranchHead(testGitHubRepo, "master", "980a0d5f19a64b4b30a87d4206aade58726b60e3")
+	applier := makeApplier(t, m, createConfigs())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := applier.UpdateRepositories(ctx, "new-image")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+	if want := "update stopped before repositories testRepo: context canceled"; err.Error() != want {
+		t.Fatalf("got error %s, want %s", err, want)
+	}
+	m.AssertNoBranchesCreated()
+	want := []Result{{Key: "testRepo", Status: StatusNotStarted, Err: context.Canceled}}
+	if diff := cmp.Diff(want, applier.Results(), cmpopts.EquateErrors()); diff != "" {
+		t.Errorf("Results() failed diff\n%s", diff)
+	}
+}
+
+func TestCurrentValue(t *testing.T) {
+	m := mock.New(t)
+	m.AddFileContents(testGitHubRepo, testFilePath, "master", []byte("test:\n  image: old-image\n  replicas: 2\n"))
+	valueTests := []struct {
+		updateKey string
+		want      string
+		wantErr   string
+	}{
+		{"test.image", "old-image", ""},
+		{"test.replicas", "2", ""},
+		{"test.missing", "", "key test.missing not found"},
+	}
+
+	for _, tt := range valueTests {
+		t.Run(tt.updateKey, func(rt *testing.T) {
+			configs := createConfigs()
+			configs.Repositories["testRepo"].UpdateKey = tt.updateKey
+			applier := makeApplier(rt, m, configs)
+
+			got, err := applier.CurrentValue(context.Background(), configs.Repositories["testRepo"])
+			if !test.MatchError(rt, tt.wantErr, err) {
+				rt.Fatalf("got error %v, want %s", err, tt.wantErr)
+			}
+			if got != tt.want {
+				rt.Fatalf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestUpdaterWithBranchCreationFailure(t *testing.T) {
 	testSHA := "980a0d5f19a64b4b30a87d4206aade58726b60e3"
 	m := mock.New(t)
@@ -248,8 +1063,8 @@ func TestUpdaterWithBranchCreationFailure(t *testing.T) {
 
 	err := applier.UpdateRepositories(context.Background(), newValue)
 
-	if err.Error() != "failed to create branch: can't create branch" {
-		t.Fatalf("got %s, want %s", err, "failed to create branch: can't create branch")
+	if err.Error() != "failed to update repository testRepo: failed to create branch: can't create branch" {
+		t.Fatalf("got %s, want %s", err, "failed to update repository testRepo: failed to create branch: can't create branch")
 	}
 	updated := m.GetUpdatedContents(testGitHubRepo, testFilePath, "test-branch-a")
 	if s := string(updated); s != "" {
@@ -271,8 +1086,8 @@ func TestUpdaterWithUpdateFileFailure(t *testing.T) {
 
 	err := applier.UpdateRepositories(context.Background(), newValue)
 
-	if err.Error() != "failed to update file: can't update file" {
-		t.Fatalf("got %s, want %s", err, "failed to update file: can't update file")
+	if err.Error() != "failed to update repository testRepo: failed to update file: can't update file" {
+		t.Fatalf("got %s, want %s", err, "failed to update repository testRepo: failed to update file: can't update file")
 	}
 	updated := m.GetUpdatedContents(testGitHubRepo, testFilePath, "test-branch-a")
 	if s := string(updated); s != "" {
@@ -299,7 +1114,7 @@ func TestUpdaterWithCreatePullRequestFailure(t *testing.T) {
 
 	err := applier.UpdateRepositories(context.Background(), newValue)
 
-	if err.Error() != "failed to create pull request in repo testorg/testrepo: failed to create a pull request: failure" {
+	if err.Error() != "failed to update repository testRepo: failed to create pull request in repo testorg/testrepo: failed to create a pull request: failure" {
 		t.Fatalf("got %s, want %s", err, "failed to create a pull request: can't create pull-request")
 	}
 	updated := m.GetUpdatedContents(testGitHubRepo, testFilePath, "test-branch-a")
@@ -316,9 +1131,10 @@ func TestUpdaterWithCreatePullRequestFailure(t *testing.T) {
 	})
 }
 
-func makeApplier(t *testing.T, m *mock.MockClient, cfgs *config.RepoConfiguration) *Applier {
+func makeApplier(t *testing.T, c pkgClient.GitClient, cfgs *config.RepoConfiguration) *Applier {
 	logger := zapr.NewLogger(zaptest.NewLogger(t, zaptest.Level(zap.WarnLevel)))
-	applier := New(logger, m, cfgs, updater.NameGenerator(stubNameGenerator{name: "a"}))
+	applier := New(logger, c, cfgs)
+	applier.randomNames = stubNameGenerator{name: "a"}
 	return applier
 }
 
@@ -337,10 +1153,70 @@ func createConfigs() *config.RepoConfiguration {
 	}
 }
 
+// fileOpsClient extends the mock client with not-found errors for missing
+// files and file deletion, as needed for copy and move operations.
+type fileOpsClient struct {
+	*mock.MockClient
+	deleted []string
+}
+
+func newFileOpsClient(t *testing.T) *fileOpsClient {
+	return &fileOpsClient{MockClient: mock.New(t)}
+}
+
+func (c *fileOpsClient) GetFile(ctx context.Context, repo, ref, path string) (*scm.Content, error) {
+	content, err := c.MockClient.GetFile(ctx, repo, ref, path)
+	if err != nil && c.GetFileErr == nil {
+		return &scm.Content{}, pkgClient.SCMError{Msg: err.Error(), Status: 404}
+	}
+	return content, err
+}
+
+func (c *fileOpsClient) DeleteFile(ctx context.Context, repo, branch, path, message, previousSHA string, signature scm.Signature, content []byte) error {
+	c.deleted = append(c.deleted, fmt.Sprintf("%s:%s:%s", repo, path, branch))
+	return nil
+}
+
+// conflictClient extends the mock client to reject the first conflicts file
+// updates, as if the file had been changed concurrently (to concurrent, or
+// else with a replica added), and to record the branches created.
+type conflictClient struct {
+	*mock.MockClient
+	conflicts  int
+	concurrent string
+	branches   []string
+}
+
+func (c *conflictClient) CreateBranch(ctx context.Context, repo, branch, sha string) error {
+	c.branches = append(c.branches, branch)
+	c.AddBranchHead(repo, branch, sha)
+	return c.MockClient.CreateBranch(ctx, repo, branch, sha)
+}
+
+func (c *conflictClient) UpdateFile(ctx context.Context, repo, branch, path, message, previousSHA string, signature scm.Signature, content []byte) error {
+	if c.conflicts > 0 {
+		c.conflicts--
+		concurrent := c.concurrent
+		if concurrent == "" {
+			concurrent = "test:\n  image: old-image\n  replicas: 3\n"
+		}
+		c.AddFileContents(repo, path, branch, []byte(concurrent))
+		return pkgClient.SCMError{Msg: "sha does not match", Status: 409}
+	}
+	return c.MockClient.UpdateFile(ctx, repo, branch, path, message, previousSHA, signature, content)
+}
+
+// stubFinder is a PullRequestFinder with the open pull requests by branch.
+type stubFinder map[string]*scm.PullRequest
+
+func (f stubFinder) FindOpenPullRequest(ctx context.Context, repo, branch string) (*scm.PullRequest, error) {
+	return f[branch], nil
+}
+
 type stubNameGenerator struct {
 	name string
 }
 
-func (s stubNameGenerator) PrefixedName(p string) string {
-	return p + s.name
+func (s stubNameGenerator) UpdateName(u names.Update) (string, error) {
+	return u.Prefix + s.name, nil
 }
@@ -0,0 +1,131 @@
+package applier
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/ocraviotto/pkg/updater"
+	"github.com/ocraviotto/yaml-updater/pkg/config"
+	"github.com/ocraviotto/yaml-updater/pkg/names"
+)
+
+// newRandomNames returns the generator of random branch names, sanitized and
+// shortened as the other schemes are.
+func newRandomNames() names.UpdateGenerator {
+	g := names.New(rand.New(rand.NewSource(time.Now().UnixNano())))
+	g.MaxLength = names.DefaultMaxLength
+	return g
+}
+
+// branchNaming returns the branchNaming of cfg, template if it only has a
+// branchTemplate, or random if it has neither.
+func branchNaming(cfg *config.Repository) string {
+	switch {
+	case cfg.BranchNaming != "":
+		return cfg.BranchNaming
+	case cfg.BranchTemplate != "":
+		return "template"
+	}
+	return "random"
+}
+
+// branchGenerator returns the generator of the name of the branch for the
+// PR of cfg.
+func (u *Applier) branchGenerator(cfg *config.Repository) (names.UpdateGenerator, error) {
+	switch branchNaming(cfg) {
+	case "random":
+		return u.randomNames, nil
+	case "hash":
+		return names.Hash{}, nil
+	case "timestamp":
+		return names.Timestamp{}, nil
+	case "template":
+		if cfg.BranchTemplate == "" {
+			return nil, fmt.Errorf("branchNaming template requires branchTemplate")
+		}
+		return names.NewTemplate(cfg.BranchTemplate)
+	}
+	return nil, fmt.Errorf("unknown branchNaming %s, must be random, hash, timestamp or template", cfg.BranchNaming)
+}
+
+// withBranchName returns a copy of the Applier that creates the branch for
+// the PR of cfg with the name given by its branchNaming, or the Applier itself
+// if no PR is created. Random names are generated once, so that retries
+// commit to the same branch.
+func (u *Applier) withBranchName(key string, cfg *config.Repository, newValue string) (*Applier, error) {
+	if cfg.DisablePRCreation {
+		return u, nil
+	}
+	g, err := u.branchGenerator(cfg)
+	if err != nil {
+		return nil, err
+	}
+	name, err := g.UpdateName(names.Update{Prefix: cfg.BranchGenerateName, Key: key, Name: cfg.Name, Repo: cfg.SourceRepo, Value: newValue})
+	if err != nil {
+		return nil, err
+	}
+	a := u.withFixedName(name)
+	a.reusable = branchNaming(cfg) != "random"
+	return a, nil
+}
+
+// withFixedName returns a copy of the Applier that creates the branch for a
+// PR with name.
+func (u *Applier) withFixedName(name string) *Applier {
+	opts := append(append([]updater.UpdaterFunc{}, u.updaterOpts...), updater.NameGenerator(names.Fixed(name)))
+	a := *u
+	a.branchName = name
+	a.updater = updater.New(u.log, u.client, opts...)
+	return &a
+}
+
+// reuseBranch returns ci changed to commit straight to the branch named by
+// withBranchName, and true, when that branch exists from a previous run and
+// can take the update: it has an open PR, or no commits of its own.
+//
+// Otherwise a branch left by a previous run, e.g. with a merged PR, could be
+// based on an old head of the source branch, so the Applier returned names the
+// branch after the current head too. That one is created from the current
+// head, or reused if a previous run for the same head already did.
+//
+// As drivers differ in how they report a missing branch, any error getting it
+// is taken as the branch not existing, and creating it reports the problem.
+func (u *Applier) reuseBranch(ctx context.Context, ci updater.CommitInput) (*Applier, updater.CommitInput, bool, error) {
+	if u.branchName == "" || ci.DisablePRCreation {
+		return u, ci, false, nil
+	}
+	sha, err := u.client.GetBranchHead(ctx, ci.Repo, u.branchName)
+	if err != nil || sha == "" {
+		return u, ci, false, nil
+	}
+	head, err := u.client.GetBranchHead(ctx, ci.Repo, ci.Branch)
+	if err != nil {
+		return nil, ci, false, fmt.Errorf("failed to get the head of branch %s in repo %s: %w", ci.Branch, ci.Repo, err)
+	}
+	if sha != head {
+		proposed, err := u.hasOpenPullRequest(ctx, ci.Repo, u.branchName)
+		if err != nil {
+			return nil, ci, false, err
+		}
+		if !proposed {
+			u.log.Info("not reusing the branch as it may be outdated", "branch", u.branchName)
+			u = u.withFixedName(names.WithSuffix(u.branchName, shortSHA(head)))
+			if sha, err := u.client.GetBranchHead(ctx, ci.Repo, u.branchName); err != nil || sha == "" {
+				return u, ci, false, nil
+			}
+		}
+	}
+	ci.Branch = u.branchName
+	ci.DisablePRCreation = true
+	return u, ci, true, nil
+}
+
+// shortSHA returns the abbreviated form of a commit SHA.
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
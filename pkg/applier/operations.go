@@ -0,0 +1,233 @@
+package applier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+
+	"github.com/ocraviotto/pkg/client"
+	"github.com/ocraviotto/pkg/updater"
+	"github.com/ocraviotto/yaml-updater/pkg/config"
+	"github.com/ocraviotto/yaml-updater/pkg/retry"
+	"sigs.k8s.io/yaml"
+)
+
+// errUnchanged is returned by applyFileOperation when the update would not
+// change the file, in which case nothing is written.
+var errUnchanged = errors.New("file content is unchanged")
+
+// keepContents is a ContentUpdater that returns the body unchanged, used when
+// copying or moving a file without updating a key.
+func keepContents(b []byte) ([]byte, error) {
+	return b, nil
+}
+
+// replaceWith is a ContentUpdater that hands body to f in place of the
+// current content of the file.
+func replaceWith(body []byte, f updater.ContentUpdater) updater.ContentUpdater {
+	return func([]byte) ([]byte, error) {
+		return f(body)
+	}
+}
+
+// detectUnchanged wraps f to fail, and set unchanged, when the updated content
+// is the same YAML as the current one of the file written to. As the updater
+// applies f before creating any branch or commit, this stops the update
+// without writes.
+func detectUnchanged(f updater.ContentUpdater, unchanged *bool) updater.ContentUpdater {
+	return func(b []byte) ([]byte, error) {
+		updated, err := f(b)
+		if err != nil {
+			return nil, err
+		}
+		if sameYAML(b, updated) {
+			*unchanged = true
+			return nil, errUnchanged
+		}
+		return updated, nil
+	}
+}
+
+// sameYAML returns true if a and b are equal, or hold the same single YAML
+// document regardless of its formatting.
+func sameYAML(a, b []byte) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	if len(bytes.TrimSpace(a)) == 0 || len(bytes.TrimSpace(b)) == 0 {
+		return false
+	}
+	// only the first document is compared, so any other one could differ
+	separator := []byte("\n---")
+	if bytes.Contains(a, separator) || bytes.Contains(b, separator) {
+		return false
+	}
+	var av, bv interface{}
+	if err := unmarshalYAML(a, &av); err != nil {
+		return false
+	}
+	if err := unmarshalYAML(b, &bv); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(av, bv)
+}
+
+func unmarshalYAML(b []byte, v interface{}) error {
+	j, err := yaml.YAMLToJSON(b)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(j, v)
+}
+
+// unchangedInSource returns true if the update of cfg with f would leave the
+// file on its sourceBranch as it is, whatever the branch it is committed to.
+// Errors are left for the update to report, as are moves and removals, which
+// always change the file.
+func (u *Applier) unchangedInSource(ctx context.Context, cfg *config.Repository, f updater.ContentUpdater) bool {
+	if cfg.MoveTo != "" || cfg.RemoveFile {
+		return false
+	}
+	current, err := u.client.GetFile(ctx, cfg.SourceRepo, cfg.SourceBranch, cfg.FilePath)
+	if err != nil {
+		return false
+	}
+	body := current.Data
+	if cfg.CopyFrom != "" {
+		src, err := u.client.GetFile(ctx, cfg.SourceRepo, cfg.SourceBranch, cfg.CopyFrom)
+		if err != nil {
+			return false
+		}
+		body = src.Data
+	}
+	updated, err := f(body)
+	return err == nil && sameYAML(current.Data, updated)
+}
+
+// applyFileOperation applies the change in f to the file in ci, copying or
+// moving it first when the repository config asks for it, and returns the
+// branch the changes were committed to, and true if a retry committed them to
+// the branch created by a previous attempt, instead of creating one.
+//
+// Unless the file is moved or removed, it returns errUnchanged without
+// writing anything when the change would leave the file as it is. The branch
+// may still have been created then, by an attempt that conflicted.
+func (u *Applier) applyFileOperation(ctx context.Context, cfg *config.Repository, ci updater.CommitInput, f updater.ContentUpdater) (string, bool, error) {
+	var unchanged bool
+	branch, retried, err := u.applyFileChange(ctx, cfg, ci, f, &unchanged)
+	if unchanged {
+		return "", retried, errUnchanged
+	}
+	return branch, retried, err
+}
+
+// applyFileChange applies the file operation of cfg, setting unchanged when
+// the file written to already has the content it would get.
+func (u *Applier) applyFileChange(ctx context.Context, cfg *config.Repository, ci updater.CommitInput, f updater.ContentUpdater, unchanged *bool) (string, bool, error) {
+	switch {
+	case cfg.CopyFrom != "" && cfg.MoveTo != "":
+		return "", false, fmt.Errorf("copyFrom and moveTo can not be used together for file %s", cfg.FilePath)
+	case (cfg.CopyFrom != "" || cfg.MoveTo != "") && cfg.RemoveFile:
+		return "", false, fmt.Errorf("removeFile can not be used along copyFrom or moveTo for file %s", cfg.FilePath)
+	case cfg.CopyFrom != "":
+		src, err := u.client.GetFile(ctx, cfg.SourceRepo, cfg.SourceBranch, cfg.CopyFrom)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to get file %s to copy: %w", cfg.CopyFrom, err)
+		}
+		ci.CreateMissing = true
+		// The copy is compared with the current destination, which is what
+		// the updater hands to the outermost ContentUpdater.
+		return u.applyUpdateToFile(ctx, ci, detectUnchanged(replaceWith(src.Data, f), unchanged))
+	case cfg.MoveTo != "":
+		src, err := u.client.GetFile(ctx, cfg.SourceRepo, cfg.SourceBranch, cfg.FilePath)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to get file %s to move: %w", cfg.FilePath, err)
+		}
+		create := ci
+		create.Filename = cfg.MoveTo
+		create.CreateMissing = true
+		newBranch, retried, err := u.applyUpdateToFile(ctx, create, replaceWith(src.Data, f))
+		if err != nil {
+			return "", retried, err
+		}
+		// The removal goes into the same branch as the new file so that both
+		// end up in a single PR (or straight in the source branch).
+		remove := ci
+		remove.Branch = newBranch
+		remove.DisablePRCreation = true
+		remove.CreateMissing = false
+		remove.RemoveFile = true
+		if _, _, err := u.applyUpdateToFile(ctx, remove, keepContents); err != nil {
+			return "", retried, err
+		}
+		return newBranch, retried, nil
+	}
+	if !ci.RemoveFile {
+		f = detectUnchanged(f, unchanged)
+	}
+	return u.applyUpdateToFile(ctx, ci, f)
+}
+
+// applyUpdateToFile calls the updater, retrying with the configured backoff
+// when the commit conflicts with a concurrent change to the branch or file.
+// Each attempt fetches the file again and reapplies f to it.
+//
+// It returns the branch committed to, and true if a retry committed to the
+// branch created by a previous attempt, which then needs proposing even if
+// the retry fails or finds the file unchanged.
+func (u *Applier) applyUpdateToFile(ctx context.Context, ci updater.CommitInput, f updater.ContentUpdater) (string, bool, error) {
+	var (
+		branch  string
+		retried bool
+		attempt int
+	)
+	err := retry.Do(ctx, u.backoff, isConflict, func() error {
+		attempt++
+		if attempt > 1 {
+			u.log.Info("retrying update after a conflict", "file", ci.Filename, "branch", ci.Branch, "attempt", attempt)
+			if !retried {
+				ci, retried = u.createdBranch(ctx, ci)
+			}
+		}
+		var err error
+		branch, err = u.updater.ApplyUpdateToFile(ctx, ci, f)
+		return err
+	})
+	return branch, retried, err
+}
+
+// createdBranch returns ci changed to commit straight to the branch for the
+// PR, and true, when a previous attempt created it. Otherwise it returns ci
+// as it is.
+func (u *Applier) createdBranch(ctx context.Context, ci updater.CommitInput) (updater.CommitInput, bool) {
+	if u.branchName == "" || ci.DisablePRCreation {
+		return ci, false
+	}
+	if sha, err := u.client.GetBranchHead(ctx, ci.Repo, u.branchName); err != nil || sha == "" {
+		return ci, false
+	}
+	ci.Branch = u.branchName
+	ci.DisablePRCreation = true
+	return ci, true
+}
+
+// shaMismatch matches the messages of the Git services that reject a commit
+// made on an outdated file with 422 Unprocessable Entity, like GitHub when the
+// file was created concurrently or Gitea when its sha changed.
+var shaMismatch = regexp.MustCompile(`(?is)\bsha\b.*\b(match|supplied)`)
+
+// isConflict returns true if err is a rejection of a commit made on an
+// outdated file or branch. As 422 is also returned for invalid requests, it
+// is only taken as a conflict when its message is about the sha.
+func isConflict(err error) bool {
+	var e client.SCMError
+	if !errors.As(err, &e) {
+		return false
+	}
+	return e.Status == http.StatusConflict || (e.Status == http.StatusUnprocessableEntity && shaMismatch.MatchString(e.Msg))
+}
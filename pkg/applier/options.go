@@ -0,0 +1,90 @@
+package applier
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ocraviotto/go-scm/scm"
+	"github.com/ocraviotto/pkg/client"
+	"github.com/ocraviotto/pkg/updater"
+	"github.com/ocraviotto/yaml-updater/pkg/config"
+	"github.com/ocraviotto/yaml-updater/pkg/retry"
+)
+
+// Option is an option for configuring an Applier after creating it.
+type Option func(a *Applier)
+
+// With applies the options to the Applier and returns it.
+func (u *Applier) With(opts ...Option) *Applier {
+	for _, o := range opts {
+		o(u)
+	}
+	return u
+}
+
+// ValueSources sets the repositories that a valueFrom is looked up in, when
+// they differ from the ones being updated, e.g. when some were filtered out.
+func ValueSources(c *config.RepoConfiguration) Option {
+	return func(a *Applier) {
+		a.sources = c
+	}
+}
+
+// FailFast makes UpdateRepositories stop at the first failed repository,
+// instead of continuing with the rest.
+func FailFast() Option {
+	return func(a *Applier) {
+		a.failFast = true
+	}
+}
+
+// Retries sets the backoff to retry a file update with when it conflicts with
+// a concurrent change, which is not retried by default.
+func Retries(b retry.Backoff) Option {
+	return func(a *Applier) {
+		a.backoff = b
+	}
+}
+
+// Connections sets the clients for the repositories with a connection, by
+// connection key. The rest use the client the Applier was created with.
+func Connections(clients map[string]client.GitClient) Option {
+	return func(a *Applier) {
+		a.clients = clients
+	}
+}
+
+// PullRequestFinder finds the open pull request from a branch, e.g. a
+// tracking.SCMService.
+type PullRequestFinder interface {
+	// FindOpenPullRequest returns nil if branch has no open pull request.
+	FindOpenPullRequest(ctx context.Context, repo, branch string) (*scm.PullRequest, error)
+}
+
+// PullRequestFinders sets what finds the pull request of a branch reused from
+// a previous run, by connection key, and the empty key for the client the
+// Applier was created with. Without one, reused branches are taken to have a
+// pull request already.
+func PullRequestFinders(finders map[string]PullRequestFinder) Option {
+	return func(a *Applier) {
+		a.finders = finders
+		a.finder = finders[""]
+	}
+}
+
+// connection returns a copy of the Applier that uses the client for the
+// named connection, or the Applier itself if name is empty.
+func (u *Applier) connection(name string) (*Applier, error) {
+	if name == "" {
+		return u, nil
+	}
+	c, ok := u.clients[name]
+	if !ok {
+		return nil, fmt.Errorf("connection %s is not configured", name)
+	}
+	a := *u
+	a.client = c
+	a.finder = u.finders[name]
+	a.updater = updater.New(u.log, c, u.updaterOpts...)
+	return &a, nil
+}
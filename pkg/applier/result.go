@@ -0,0 +1,106 @@
+package applier
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Status is the outcome of updating a repository.
+type Status string
+
+const (
+	// StatusUpdated is a repository whose update was applied.
+	StatusUpdated Status = "updated"
+	// StatusUnchanged is a repository whose file already had the update
+	// applied, so nothing was written.
+	StatusUnchanged Status = "unchanged"
+	// StatusFailed is a repository whose update failed.
+	StatusFailed Status = "failed"
+	// StatusSkipped is a repository whose update was not attempted, because
+	// a dependency failed or after a failure with FailFast.
+	StatusSkipped Status = "skipped"
+	// StatusNotStarted is a repository whose update was not attempted as the
+	// context was done, e.g. on a timeout or interrupt.
+	StatusNotStarted Status = "not started"
+)
+
+// Result is the outcome of updating the repository with Key.
+type Result struct {
+	Key    string
+	Status Status
+	// Err is the reason the update failed or was skipped.
+	Err error
+}
+
+// UpdateError is returned by UpdateRepositories when the update of any
+// repository failed, with the results of all of them.
+type UpdateError struct {
+	Results []Result
+}
+
+// Failed returns the keys of the repositories whose update failed.
+func (e *UpdateError) Failed() []string {
+	return e.keys(StatusFailed)
+}
+
+// Partial returns true if some repositories were updated, or were already
+// up to date, despite the failures.
+func (e *UpdateError) Partial() bool {
+	for _, r := range e.Results {
+		if r.Status == StatusUpdated || r.Status == StatusUnchanged {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *UpdateError) Error() string {
+	failed := e.Failed()
+	msgs := make([]string, 0, len(failed))
+	for _, r := range e.Results {
+		if r.Status == StatusFailed {
+			msgs = append(msgs, fmt.Sprintf("%s: %s", r.Key, r.Err))
+		}
+	}
+	notStarted := e.keys(StatusNotStarted)
+	var msg string
+	switch len(failed) {
+	case 0:
+		msg = fmt.Sprintf("update stopped before repositories %s: %s", strings.Join(notStarted, ", "), e.Unwrap())
+		notStarted = nil
+	case 1:
+		msg = fmt.Sprintf("failed to update repository %s", strings.Join(msgs, "; "))
+	default:
+		msg = fmt.Sprintf("failed to update repositories %s: %s", strings.Join(failed, ", "), strings.Join(msgs, "; "))
+	}
+	if skipped := e.keys(StatusSkipped); len(skipped) > 0 {
+		msg += fmt.Sprintf(" (skipped %s)", strings.Join(skipped, ", "))
+	}
+	if len(notStarted) > 0 {
+		msg += fmt.Sprintf(" (not started %s)", strings.Join(notStarted, ", "))
+	}
+	return msg
+}
+
+// Unwrap returns the error of the first repository that failed, or else the
+// reason the rest were not started.
+func (e *UpdateError) Unwrap() error {
+	for _, s := range []Status{StatusFailed, StatusNotStarted} {
+		for _, r := range e.Results {
+			if r.Status == s {
+				return r.Err
+			}
+		}
+	}
+	return nil
+}
+
+func (e *UpdateError) keys(s Status) []string {
+	var keys []string
+	for _, r := range e.Results {
+		if r.Status == s {
+			keys = append(keys, r.Key)
+		}
+	}
+	return keys
+}
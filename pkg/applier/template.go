@@ -0,0 +1,73 @@
+package applier
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"text/template"
+
+	"github.com/ocraviotto/pkg/updater"
+	"github.com/ocraviotto/yaml-updater/pkg/config"
+)
+
+// repoTemplatePrefix marks a templateFile that is read from the repository
+// being updated, at the configured sourceBranch, instead of the local disk.
+const repoTemplatePrefix = "repo:"
+
+// templateData is what a createMissing template is rendered with.
+type templateData struct {
+	Key   string
+	Name  string
+	Value string
+}
+
+// loadTemplate returns the parsed template for cfg, which must have one set.
+func (u *Applier) loadTemplate(ctx context.Context, cfg *config.Repository) (*template.Template, error) {
+	body := cfg.Template
+	switch {
+	case body != "":
+	case strings.HasPrefix(cfg.TemplateFile, repoTemplatePrefix):
+		path := strings.TrimPrefix(cfg.TemplateFile, repoTemplatePrefix)
+		content, err := u.client.GetFile(ctx, cfg.SourceRepo, cfg.SourceBranch, path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get template %s from repo %s: %w", path, cfg.SourceRepo, err)
+		}
+		body = string(content.Data)
+	case cfg.TemplateFile != "":
+		b, err := ioutil.ReadFile(cfg.TemplateFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template: %w", err)
+		}
+		body = string(b)
+	default:
+		return nil, fmt.Errorf("no template set for file %s", cfg.FilePath)
+	}
+	t, err := template.New("createMissing").Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %w", err)
+	}
+	return t, nil
+}
+
+// seedFromTemplate is a ContentUpdater that renders the template of cfg as the
+// initial body when the current file is missing (or empty), before handing it
+// over to f. The template is only loaded then, so that it does not fail, nor
+// cost a request, the updates of existing files.
+func (u *Applier) seedFromTemplate(ctx context.Context, cfg *config.Repository, data templateData, f updater.ContentUpdater) updater.ContentUpdater {
+	return func(b []byte) ([]byte, error) {
+		if len(bytes.TrimSpace(b)) == 0 {
+			t, err := u.loadTemplate(ctx, cfg)
+			if err != nil {
+				return nil, err
+			}
+			var buf bytes.Buffer
+			if err := t.Execute(&buf, data); err != nil {
+				return nil, fmt.Errorf("failed to render template: %w", err)
+			}
+			b = buf.Bytes()
+		}
+		return f(b)
+	}
+}
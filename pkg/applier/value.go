@@ -0,0 +1,61 @@
+package applier
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ocraviotto/yaml-updater/pkg/config"
+	"github.com/tidwall/gjson"
+	"sigs.k8s.io/yaml"
+)
+
+// CurrentValue fetches the file for the repository config from its
+// sourceBranch and returns the value at its updateKey.
+func (u *Applier) CurrentValue(ctx context.Context, cfg *config.Repository) (string, error) {
+	u, err := u.connection(cfg.Connection)
+	if err != nil {
+		return "", err
+	}
+	content, err := u.client.GetFile(ctx, cfg.SourceRepo, cfg.SourceBranch, cfg.FilePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to get file %s from repo %s: %w", cfg.FilePath, cfg.SourceRepo, err)
+	}
+	j, err := yaml.YAMLToJSON(content.Data)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse file %s from repo %s: %w", cfg.FilePath, cfg.SourceRepo, err)
+	}
+	res := gjson.GetBytes(j, cfg.UpdateKey)
+	if !res.Exists() {
+		return "", fmt.Errorf("key %s not found in file %s from repo %s", cfg.UpdateKey, cfg.FilePath, cfg.SourceRepo)
+	}
+	return res.String(), nil
+}
+
+// valueFor returns the value to apply to the repository config, which is
+// the one read from another repository key with valueFrom, or else its own
+// value, or else newValue.
+func (u *Applier) valueFor(ctx context.Context, cfg *config.Repository, newValue string) (string, error) {
+	if cfg.ValueFrom == "" {
+		if cfg.Value != "" {
+			return cfg.Value, nil
+		}
+		return newValue, nil
+	}
+	sources := u.sources
+	if sources == nil {
+		sources = u.configs
+	}
+	var src *config.Repository
+	if sources != nil {
+		src = sources.Find(cfg.ValueFrom)
+	}
+	if src == nil {
+		return "", fmt.Errorf("valueFrom repository %s does not exist in the current repositories config", cfg.ValueFrom)
+	}
+	value, err := u.CurrentValue(ctx, src)
+	if err != nil {
+		return "", fmt.Errorf("failed to read value from repository %s: %w", cfg.ValueFrom, err)
+	}
+	u.log.Info("read value from repository", "valueFrom", cfg.ValueFrom, "value", value)
+	return value, nil
+}
@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/ocraviotto/yaml-updater/pkg/config"
+	"github.com/ocraviotto/yaml-updater/pkg/tracking"
+)
+
+func makeCleanupCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cleanup",
+		Short: "delete the branches of merged or abandoned PRs",
+		Long: "Lists the branches prefixed with the branchGenerateName of each enabled repository configuration, " +
+			"and deletes those whose latest PR is merged or closed, and with --older-than, those without a PR whose last commit is older. " +
+			"Branches with an open PR are kept, unless --close-stale is set too and their last commit is older, in which case the PR is closed first",
+		PreRun: func(cmd *cobra.Command, args []string) {
+			// Bound when run, as other commands have an output flag too.
+			logIfError(viper.BindPFlag("output", cmd.Flags().Lookup("output")))
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			output := viper.GetString("output")
+			if output != outputTable && output != outputJSON {
+				return fmt.Errorf("unknown output format %q, must be one of %s or %s", output, outputTable, outputJSON)
+			}
+			olderThan, err := parseAge(viper.GetString("older-than"))
+			if err != nil {
+				return err
+			}
+			closeStale := viper.GetBool("close-stale")
+			if closeStale && olderThan == 0 {
+				return fmt.Errorf("--close-stale requires --older-than")
+			}
+			ctx, cancel := commandContext(cmd)
+			defer cancel()
+			repositories, err := loadConfig(ctx, false)
+			if err != nil {
+				return fmt.Errorf("failed to read repositories yaml config from file: %w", err)
+			}
+			// The source branches of every entry are kept, selected or not.
+			all := repositories.Clone()
+			if err := selectRepositories(repositories); err != nil {
+				return err
+			}
+			services, err := trackingServices(repositories)
+			if err != nil {
+				return err
+			}
+			opts := tracking.CleanupOptions{OlderThan: olderThan, CloseStale: closeStale, DryRun: viper.GetBool("dry-run")}
+			var (
+				cleaned []tracking.CleanedBranch
+				failed  int
+			)
+			for _, t := range tracking.ProtectSourceBranches(tracking.Targets(repositories), all) {
+				branches, err := tracking.Cleanup(ctx, services[t.Connection], t, opts)
+				if err != nil {
+					branches = []tracking.CleanedBranch{{Keys: t.Keys, Repo: t.Repo, Action: tracking.ActionFailed, Error: err.Error()}}
+				}
+				for _, b := range branches {
+					if b.Action == tracking.ActionFailed {
+						failed++
+					}
+				}
+				cleaned = append(cleaned, branches...)
+			}
+			if err := printCleanedBranches(cmd.OutOrStdout(), output, cleaned); err != nil {
+				return err
+			}
+			if failed > 0 {
+				return fmt.Errorf("failed to clean up %d branches", failed)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool(
+		"dry-run",
+		false,
+		"Report the branches that would be deleted, without deleting them",
+	)
+	logIfError(viper.BindPFlag("dry-run", cmd.Flags().Lookup("dry-run")))
+
+	cmd.Flags().String(
+		"older-than",
+		"",
+		"Also delete the branches without a PR whose last commit is older than this, e.g. 30d or 12h. They are kept by default",
+	)
+	logIfError(viper.BindPFlag("older-than", cmd.Flags().Lookup("older-than")))
+
+	cmd.Flags().Bool(
+		"close-stale",
+		false,
+		"Also close the open PRs whose branch has no commit newer than --older-than, and delete their branches",
+	)
+	logIfError(viper.BindPFlag("close-stale", cmd.Flags().Lookup("close-stale")))
+
+	cmd.Flags().StringP(
+		"output",
+		"o",
+		outputTable,
+		"Output format of the report, one of table or json",
+	)
+
+	return cmd
+}
+
+// trackingServices returns the services to track the branches and PRs of each
+// connection in configs with, by connection key, and the one given with the
+// flags by the empty key. They always use the API, whatever the backend.
+func trackingServices(configs *config.RepoConfiguration) (map[string]tracking.Service, error) {
+	c, err := newClient(clientOptionsFromViper())
+	if err != nil {
+		return nil, err
+	}
+	services := map[string]tracking.Service{"": tracking.New(c)}
+	for name, conn := range configs.Connections {
+		c, err := newClient(clientOptionsFromConnection(conn))
+		if err != nil {
+			return nil, fmt.Errorf("connection %s: %w", name, err)
+		}
+		services[name] = tracking.New(c)
+	}
+	return services, nil
+}
+
+// parseAge parses s as time.ParseDuration does, also accepting a number of
+// days, e.g. 30d. It returns 0 for an empty s.
+func parseAge(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q, must be a number of days like 30d or a duration like 12h", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q, must be a number of days like 30d or a duration like 12h", s)
+	}
+	return d, nil
+}
+
+func printCleanedBranches(w io.Writer, output string, cleaned []tracking.CleanedBranch) error {
+	if output == outputJSON {
+		if cleaned == nil {
+			cleaned = []tracking.CleanedBranch{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(cleaned)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEYS\tREPOSITORY\tBRANCH\tACTION\tREASON")
+	for _, b := range cleaned {
+		reason := b.Reason
+		if b.Error != "" {
+			reason = strings.TrimPrefix(reason+", error: "+b.Error, ", ")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", strings.Join(b.Keys, ","), b.Repo, b.Branch, b.Action, reason)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/viper"
+
+	"github.com/ocraviotto/yaml-updater/pkg/tracking"
+	"github.com/ocraviotto/yaml-updater/test"
+)
+
+func TestPrintCleanedBranches(t *testing.T) {
+	cleaned := []tracking.CleanedBranch{
+		{Keys: []string{"testRepo1", "testRepo2"}, Repo: "my-org/my-project", Branch: "gitops-aaaaa", PullRequest: 1, Action: tracking.ActionDeleted, Reason: "pull request #1 is merged"},
+		{Keys: []string{"testRepo1", "testRepo2"}, Repo: "my-org/my-project", Branch: "gitops-bbbbb", Action: tracking.ActionKeep, Reason: "no pull request"},
+		{Keys: []string{"testRepo3"}, Repo: "my-org/my-other-project", Action: tracking.ActionFailed, Error: "not found"},
+	}
+	printTests := []struct {
+		output string
+		want   string
+	}{
+		{
+			outputTable,
+			"KEYS                 REPOSITORY               BRANCH        ACTION   REASON\n" +
+				"testRepo1,testRepo2  my-org/my-project        gitops-aaaaa  deleted  pull request #1 is merged\n" +
+				"testRepo1,testRepo2  my-org/my-project        gitops-bbbbb  keep     no pull request\n" +
+				"testRepo3            my-org/my-other-project                failed   error: not found\n",
+		},
+		{
+			outputJSON,
+			`[
+  {
+    "keys": [
+      "testRepo1",
+      "testRepo2"
+    ],
+    "sourceRepo": "my-org/my-project",
+    "branch": "gitops-aaaaa",
+    "pullRequest": 1,
+    "action": "deleted",
+    "reason": "pull request #1 is merged"
+  },
+  {
+    "keys": [
+      "testRepo1",
+      "testRepo2"
+    ],
+    "sourceRepo": "my-org/my-project",
+    "branch": "gitops-bbbbb",
+    "action": "keep",
+    "reason": "no pull request"
+  },
+  {
+    "keys": [
+      "testRepo3"
+    ],
+    "sourceRepo": "my-org/my-other-project",
+    "branch": "",
+    "action": "failed",
+    "reason": "",
+    "error": "not found"
+  }
+]
+`,
+		},
+	}
+
+	for _, tt := range printTests {
+		t.Run(tt.output, func(rt *testing.T) {
+			var b bytes.Buffer
+			if err := printCleanedBranches(&b, tt.output, cleaned); err != nil {
+				rt.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, b.String()); diff != "" {
+				rt.Errorf("printCleanedBranches(%s) failed diff\n%s", tt.output, diff)
+			}
+		})
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	ageTests := []struct {
+		age     string
+		want    time.Duration
+		wantErr string
+	}{
+		{"", 0, ""},
+		{"30d", 30 * 24 * time.Hour, ""},
+		{"12h", 12 * time.Hour, ""},
+		{"1.5d", 0, `invalid age "1.5d", must be a number of days like 30d or a duration like 12h`},
+		{"-1h", 0, `invalid age "-1h", must be a number of days like 30d or a duration like 12h`},
+		{"month", 0, `invalid age "month", must be a number of days like 30d or a duration like 12h`},
+	}
+
+	for _, tt := range ageTests {
+		got, err := parseAge(tt.age)
+		if !test.MatchError(t, tt.wantErr, err) {
+			t.Errorf("parseAge(%q) got error %v, want %q", tt.age, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("parseAge(%q) got %s, want %s", tt.age, got, tt.want)
+		}
+	}
+}
+
+func TestCleanupCloseStaleRequiresOlderThan(t *testing.T) {
+	initViper()
+	cmd := makeCleanupCmd()
+	cmd.PreRun(cmd, nil)
+	viper.Set("close-stale", true)
+
+	err := cmd.RunE(cmd, nil)
+	if !test.MatchError(t, "--close-stale requires --older-than", err) {
+		t.Fatalf("got error %v", err)
+	}
+}
@@ -1,45 +1,580 @@
 package cmd
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"os"
+	"time"
 
+	"github.com/go-logr/logr"
 	"github.com/ocraviotto/go-scm/scm"
 	"github.com/ocraviotto/go-scm/scm/factory"
+	"github.com/ocraviotto/pkg/client"
 	"github.com/spf13/viper"
 	"golang.org/x/oauth2"
+
+	"github.com/ocraviotto/yaml-updater/pkg/applier"
+	"github.com/ocraviotto/yaml-updater/pkg/config"
+	"github.com/ocraviotto/yaml-updater/pkg/credentials"
+	"github.com/ocraviotto/yaml-updater/pkg/githubapp"
+	"github.com/ocraviotto/yaml-updater/pkg/gitproto"
+	"github.com/ocraviotto/yaml-updater/pkg/retry"
+	"github.com/ocraviotto/yaml-updater/pkg/tracking"
 )
 
-func createClientFromViper() (*scm.Client, error) {
-	authToken := viper.GetString(authTokenFlag)
-	driver := viper.GetString(driverFlag)
-	apiEndpoint := viper.GetString(apiEndpointFlag)
-	username := viper.GetString(usernameFlag)
-	if viper.GetBool(insecureFlag) {
-		return factory.NewClient(
-			driver,
-			apiEndpoint,
-			"",
-			factory.Client(makeInsecureClient(authToken)))
-
-	}
-	return factory.NewClient(
-		driver,
-		apiEndpoint,
-		authToken,
-		factory.SetUsername(username))
-}
-
-func makeInsecureClient(token string) *http.Client {
-	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: token},
-	)
-	return &http.Client{
-		Transport: &oauth2.Transport{
-			Source: ts,
-			Base: &http.Transport{
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-			},
-		},
+// clientOptions configures the connection to a Git service.
+type clientOptions struct {
+	driver   string
+	endpoint string
+	username string
+	token    string
+	insecure bool
+
+	caFile     string
+	clientCert string
+	clientKey  string
+	proxy      string
+
+	tokenFile        string
+	tokenCommand     string
+	credentialHelper string
+
+	appID          int64
+	installationID int64
+	appKeyFile     string
+
+	backend        string
+	gitURL         string
+	sshKeyFile     string
+	knownHostsFile string
+
+	signingFormat  string
+	signingKeyFile string
+	signingKey     string
+}
+
+// clientOptionsFromViper returns the options set with the flags.
+func clientOptionsFromViper() clientOptions {
+	return clientOptions{
+		driver:   viper.GetString(driverFlag),
+		endpoint: viper.GetString(apiEndpointFlag),
+		username: viper.GetString(usernameFlag),
+		token:    viper.GetString(authTokenFlag),
+		insecure: viper.GetBool(insecureFlag),
+
+		caFile:     viper.GetString(caFileFlag),
+		clientCert: viper.GetString(clientCertFlag),
+		clientKey:  viper.GetString(clientKeyFlag),
+		proxy:      viper.GetString(proxyFlag),
+
+		tokenFile:        viper.GetString(authTokenFileFlag),
+		tokenCommand:     viper.GetString(authTokenCommandFlag),
+		credentialHelper: viper.GetString(credentialHelperFlag),
+
+		appID:          viper.GetInt64(appIDFlag),
+		installationID: viper.GetInt64(installationIDFlag),
+		appKeyFile:     viper.GetString(appKeyFileFlag),
+
+		backend:        viper.GetString(backendFlag),
+		gitURL:         viper.GetString(gitURLFlag),
+		sshKeyFile:     viper.GetString(sshKeyFileFlag),
+		knownHostsFile: viper.GetString(knownHostsFileFlag),
+
+		signingFormat:  viper.GetString(signingFormatFlag),
+		signingKeyFile: viper.GetString(signingKeyFileFlag),
+		signingKey:     viper.GetString(signingKeyFlag),
+	}
+}
+
+// clientOptionsFromConnection returns the options for a connection in the
+// repositories configuration.
+func clientOptionsFromConnection(c *config.Connection) clientOptions {
+	o := clientOptions{
+		driver:   c.Driver,
+		endpoint: c.Endpoint,
+		username: c.Username,
+		insecure: c.Insecure,
+
+		caFile:     c.CAFile,
+		clientCert: c.ClientCert,
+		clientKey:  c.ClientKey,
+		proxy:      c.Proxy,
+
+		tokenFile:        c.TokenFile,
+		tokenCommand:     c.TokenCommand,
+		credentialHelper: c.CredentialHelper,
+
+		appID:          c.AppID,
+		installationID: c.InstallationID,
+		appKeyFile:     c.PrivateKeyFile,
+
+		backend:        c.Backend,
+		gitURL:         c.GitURL,
+		sshKeyFile:     c.SSHKeyFile,
+		knownHostsFile: c.KnownHostsFile,
+
+		signingFormat:  c.SigningFormat,
+		signingKeyFile: c.SigningKeyFile,
+	}
+	if o.driver == "" {
+		o.driver = "github"
+	}
+	if c.TokenEnv != "" {
+		o.token = os.Getenv(c.TokenEnv)
+	}
+	if c.SigningKeyEnv != "" {
+		o.signingKey = os.Getenv(c.SigningKeyEnv)
+	}
+	return o
+}
+
+// signing returns the signing of the commits in o, or nil if no key is given.
+func (o clientOptions) signing() *gitproto.Signing {
+	if o.signingKeyFile == "" && o.signingKey == "" {
+		return nil
+	}
+	credentials.AddSecret(o.signingKey)
+	return &gitproto.Signing{Format: o.signingFormat, KeyFile: o.signingKeyFile, Key: o.signingKey}
+}
+
+// source returns the source of the credentials in o, or nil if there are none.
+func (o clientOptions) source() (credentials.Source, error) {
+	given := 0
+	for _, v := range []string{o.token, o.tokenFile, o.tokenCommand, o.credentialHelper} {
+		if v != "" {
+			given++
+		}
+	}
+	if given > 1 {
+		return nil, fmt.Errorf("only one of a token, a token file, a token command or a credential helper can be given")
+	}
+	switch {
+	case o.token != "":
+		return credentials.Static(o.token), nil
+	case o.tokenFile != "":
+		return credentials.File(o.tokenFile), nil
+	case o.tokenCommand != "":
+		return &credentials.Command{Command: o.tokenCommand}, nil
+	case o.credentialHelper != "":
+		return &credentials.Helper{Helper: o.credentialHelper, URL: o.serverURL()}, nil
+	}
+	return nil, nil
+}
+
+// serverURL returns the URL of the Git service, to ask a credential helper
+// for its credentials.
+func (o clientOptions) serverURL() string {
+	if o.endpoint != "" {
+		return o.endpoint
 	}
+	switch o.driver {
+	case "gitlab":
+		return "https://gitlab.com"
+	case "bitbucket", "bitbucketcloud":
+		return "https://bitbucket.org"
+	}
+	return "https://github.com"
+}
+
+// cloneURL returns the template of the URL to clone the repositories from
+// with the git backend, see gitproto.Client.
+func (o clientOptions) cloneURL() (string, error) {
+	if o.gitURL != "" {
+		return o.gitURL, nil
+	}
+	u, err := url.Parse(o.serverURL())
+	if err != nil {
+		return "", fmt.Errorf("invalid API endpoint: %w", err)
+	}
+	return fmt.Sprintf("%s://%s/%s.git", u.Scheme, u.Host, gitproto.RepoPlaceholder), nil
+}
+
+// gitClients are the clients of the git backend, to remove their clones once
+// the command is done.
+var gitClients []*gitproto.Client
+
+// closeGitClients removes the clones of the git backend clients.
+func closeGitClients() {
+	for _, c := range gitClients {
+		_ = c.Close()
+	}
+	gitClients = nil
+}
+
+func createGitClientFromViper() (client.GitClient, error) {
+	return newGitClient(clientOptionsFromViper())
+}
+
+// newGitClient creates the GitClient for the backend in o, which for the git
+// backend only uses the API of the Git service to create pull requests.
+func newGitClient(o clientOptions) (client.GitClient, error) {
+	scmClient, err := newClient(o)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a git driver: %s", err)
+	}
+	api := apiClient{SCMClient: client.New(scmClient), scm: scmClient}
+	switch o.backend {
+	case "", "api":
+		if o.signing() != nil {
+			return nil, fmt.Errorf("signing commits needs the git backend")
+		}
+		return api, nil
+	case "git":
+	default:
+		return nil, fmt.Errorf("unknown backend %s, must be api or git", o.backend)
+	}
+	cloneURL, err := o.cloneURL()
+	if err != nil {
+		return nil, err
+	}
+	source, err := o.gitCredentials()
+	if err != nil {
+		return nil, err
+	}
+	c := &gitproto.Client{
+		URL:            cloneURL,
+		Credentials:    source,
+		Username:       o.username,
+		SSHKeyFile:     o.sshKeyFile,
+		KnownHostsFile: o.knownHostsFile,
+		PullRequests:   api,
+		Signing:        o.signing(),
+	}
+	gitClients = append(gitClients, c)
+	return c, nil
+}
+
+// gitCredentials returns the source of the credentials to clone and push with
+// the git backend, which are those of the installation of the GitHub App in o
+// if any.
+func (o clientOptions) gitCredentials() (credentials.Source, error) {
+	if o.appID == 0 {
+		return o.source()
+	}
+	base, err := o.transport()
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := o.appTokenSource(base)
+	if err != nil {
+		return nil, err
+	}
+	return appCredentials{tokens}, nil
+}
+
+// apiClient is the GitClient for the API of a Git service. It keeps the
+// message of the Git service in the errors updating and deleting files, which
+// tells a conflict from other rejections with the same status.
+type apiClient struct {
+	*client.SCMClient
+	scm *scm.Client
+}
+
+// UpdateFile implements client.GitClient.
+func (c apiClient) UpdateFile(ctx context.Context, repo, branch, path, message, previousSHA string, signature scm.Signature, content []byte) error {
+	res, err := c.scm.Contents.Update(ctx, repo, path, contentParams(branch, message, previousSHA, signature, content))
+	return fileError(res, err, fmt.Sprintf("failed to update file %s in repo %s branch %s", path, repo, branch))
+}
+
+// DeleteFile implements client.GitClient.
+func (c apiClient) DeleteFile(ctx context.Context, repo, branch, path, message, previousSHA string, signature scm.Signature, content []byte) error {
+	res, err := c.scm.Contents.Delete(ctx, repo, path, contentParams(branch, message, previousSHA, signature, content))
+	return fileError(res, err, fmt.Sprintf("failed to delete file %s in repo %s branch %s", path, repo, branch))
+}
+
+func contentParams(branch, message, previousSHA string, signature scm.Signature, content []byte) *scm.ContentParams {
+	return &scm.ContentParams{
+		Message:   message,
+		Data:      content,
+		Branch:    branch,
+		Sha:       previousSHA,
+		BlobID:    previousSHA,
+		Signature: signature,
+	}
+}
+
+// fileError returns a client.SCMError with msg and the message of the Git
+// service, if res has an error status, or else err.
+func fileError(res *scm.Response, err error, msg string) error {
+	if res == nil || res.Status < http.StatusBadRequest {
+		return err
+	}
+	if err != nil {
+		msg = fmt.Sprintf("%s: %s", msg, err)
+	}
+	return client.SCMError{Msg: msg, Status: res.Status}
+}
+
+// newClient creates a client for the Git service in o, that retries and times
+// out requests as set with the flags.
+func newClient(o clientOptions) (*scm.Client, error) {
+	c, err := newDriver(o)
+	if err != nil {
+		return nil, err
+	}
+	withTransports(c, backoffFromViper(), viper.GetDuration(requestTimeoutFlag))
+	return c, nil
+}
+
+// newDriver creates a client for the Git service in o, authenticated with the
+// credentials in o and using its TLS and proxy settings, whatever the driver.
+func newDriver(o clientOptions) (*scm.Client, error) {
+	base, err := o.transport()
+	if err != nil {
+		return nil, err
+	}
+	if o.appID != 0 {
+		return newGitHubAppDriver(o, base)
+	}
+	credentials.AddSecret(o.token)
+	source, err := o.source()
+	if err != nil {
+		return nil, err
+	}
+	if source != nil && o.driver == "bitbucketcloud" && o.username == "" && o.credentialHelper == "" {
+		return nil, fmt.Errorf("no username supplied for bitbucketcloud")
+	}
+	c, err := factory.NewClient(o.driver, o.endpoint, "", factory.SetUsername(o.username))
+	if err != nil {
+		return nil, err
+	}
+	if source == nil {
+		c.Client = &http.Client{Transport: base}
+		return c, nil
+	}
+	c.Client = &http.Client{Transport: &credentials.Transport{Driver: o.driver, Username: o.username, Source: source, Base: base}}
+	return c, nil
+}
+
+// transport returns the transport for the requests to the Git service, with
+// the TLS and proxy settings in o. Without a proxy in o, the one in the
+// HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables is used.
+func (o clientOptions) transport() (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = &tls.Config{InsecureSkipVerify: o.insecure}
+	if o.caFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		b, err := ioutil.ReadFile(o.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the CA file: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no PEM encoded certificates found in the CA file %s", o.caFile)
+		}
+		t.TLSClientConfig.RootCAs = pool
+	}
+	if o.clientCert != "" || o.clientKey != "" {
+		if o.clientCert == "" || o.clientKey == "" {
+			return nil, fmt.Errorf("a client certificate needs both the certificate and the key files")
+		}
+		cert, err := tls.LoadX509KeyPair(o.clientCert, o.clientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the client certificate: %w", err)
+		}
+		t.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+	if o.proxy != "" {
+		u, err := url.Parse(o.proxy)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q", o.proxy)
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+	return t, nil
+}
+
+// newGitHubAppDriver creates a github client authenticated as an installation
+// of a GitHub App, with tokens created with its private key and refreshed
+// before they expire. Commits and pull requests are attributed to the app.
+func newGitHubAppDriver(o clientOptions, base http.RoundTripper) (*scm.Client, error) {
+	tokens, err := o.appTokenSource(base)
+	if err != nil {
+		return nil, err
+	}
+	c, err := factory.NewClient(o.driver, o.endpoint, "")
+	if err != nil {
+		return nil, err
+	}
+	c.Client = &http.Client{Transport: &oauth2.Transport{Source: tokens, Base: base}}
+	return c, nil
+}
+
+// appTokenSource returns the tokens of the installation of the GitHub App in
+// o, created with requests sent with base.
+func (o clientOptions) appTokenSource(base http.RoundTripper) (oauth2.TokenSource, error) {
+	if o.driver != "github" {
+		return nil, fmt.Errorf("GitHub App authentication needs the github driver, not %s", o.driver)
+	}
+	if o.installationID == 0 || o.appKeyFile == "" {
+		return nil, fmt.Errorf("GitHub App authentication needs an installation ID and a private key file")
+	}
+	key, err := githubapp.LoadPrivateKey(o.appKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	c, err := factory.NewClient(o.driver, o.endpoint, "")
+	if err != nil {
+		return nil, err
+	}
+	app := githubapp.Config{
+		AppID:          o.appID,
+		InstallationID: o.installationID,
+		Key:            key,
+		BaseURL:        c.BaseURL.String(),
+		Client:         &http.Client{Transport: base, Timeout: viper.GetDuration(requestTimeoutFlag)},
+	}
+	return redactedTokenSource{app.TokenSource()}, nil
+}
+
+// redactedTokenSource redacts the tokens of a TokenSource from the output.
+type redactedTokenSource struct {
+	oauth2.TokenSource
+}
+
+func (s redactedTokenSource) Token() (*oauth2.Token, error) {
+	t, err := s.TokenSource.Token()
+	if err != nil {
+		return nil, err
+	}
+	credentials.AddSecret(t.AccessToken)
+	return t, nil
+}
+
+// appCredentials are the credentials of a GitHub App installation for git,
+// which takes its tokens with the x-access-token username.
+type appCredentials struct {
+	oauth2.TokenSource
+}
+
+// Credentials implements credentials.Source.
+func (a appCredentials) Credentials() (credentials.Credentials, error) {
+	t, err := a.Token()
+	if err != nil {
+		return credentials.Credentials{}, fmt.Errorf("failed to get a GitHub App installation token: %w", err)
+	}
+	return credentials.Credentials{Username: "x-access-token", Token: t.AccessToken}, nil
+}
+
+// connectionClients creates a client for each of the connections in configs.
+func connectionClients(configs *config.RepoConfiguration) (map[string]client.GitClient, error) {
+	clients := make(map[string]client.GitClient, len(configs.Connections))
+	for key, conn := range configs.Connections {
+		c, err := newGitClient(clientOptionsFromConnection(conn))
+		if err != nil {
+			return nil, fmt.Errorf("connection %s: %w", key, err)
+		}
+		clients[key] = c
+	}
+	return clients, nil
+}
+
+// newApplier creates an Applier for configs that uses the client set with the
+// flags, or the one for the connection of each repository, and retries as set
+// with the flags.
+func newApplier(l logr.Logger, configs *config.RepoConfiguration, opts ...applier.Option) (*applier.Applier, error) {
+	c, err := createGitClientFromViper()
+	if err != nil {
+		return nil, err
+	}
+	finders, err := pullRequestFinders(configs)
+	if err != nil {
+		return nil, err
+	}
+	opts = append([]applier.Option{applier.Retries(backoffFromViper()), applier.PullRequestFinders(finders)}, opts...)
+	if configs != nil && len(configs.Connections) > 0 {
+		clients, err := connectionClients(configs)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, applier.Connections(clients))
+	}
+	return applier.New(l, c, configs).With(opts...), nil
+}
+
+// pullRequestFinders returns what finds the pull requests of reused branches
+// with the API, for the flags by the empty key and for each connection in
+// configs, whatever the backend.
+func pullRequestFinders(configs *config.RepoConfiguration) (map[string]applier.PullRequestFinder, error) {
+	c, err := newClient(clientOptionsFromViper())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a git driver: %s", err)
+	}
+	finders := map[string]applier.PullRequestFinder{"": tracking.New(c)}
+	if configs == nil {
+		return finders, nil
+	}
+	for name, conn := range configs.Connections {
+		c, err := newClient(clientOptionsFromConnection(conn))
+		if err != nil {
+			return nil, fmt.Errorf("connection %s: %w", name, err)
+		}
+		finders[name] = tracking.New(c)
+	}
+	return finders, nil
+}
+
+// backoffFromViper returns the backoff for retries set with the flags.
+func backoffFromViper() retry.Backoff {
+	b := retry.DefaultBackoff
+	b.Attempts = viper.GetInt(retryAttemptsFlag)
+	b.Timeout = viper.GetDuration(retryTimeoutFlag)
+	return b
+}
+
+// withTransports makes the HTTP client of c retry rate limited and failed
+// requests with b, and cancel each attempt after timeout, unless it is zero.
+func withTransports(c *scm.Client, b retry.Backoff, timeout time.Duration) {
+	hc := &http.Client{}
+	if c.Client != nil {
+		*hc = *c.Client
+	}
+	base := hc.Transport
+	if timeout > 0 {
+		base = &timeoutTransport{base: base, timeout: timeout}
+	}
+	hc.Transport = &retry.Transport{Base: base, Backoff: b}
+	c.Client = hc
+}
+
+// timeoutTransport cancels the requests that take longer than timeout,
+// including reading their response body.
+type timeoutTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelBody cancels the context of its request when closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
 }
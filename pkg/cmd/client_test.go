@@ -0,0 +1,450 @@
+package cmd
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/ocraviotto/go-scm/scm"
+	"github.com/ocraviotto/pkg/client"
+	"github.com/ocraviotto/yaml-updater/pkg/credentials"
+	"github.com/ocraviotto/yaml-updater/pkg/gitproto"
+	"github.com/ocraviotto/yaml-updater/test"
+)
+
+func TestTimeoutTransport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		}
+	}))
+	defer ts.Close()
+	c := &http.Client{Transport: &timeoutTransport{timeout: 50 * time.Millisecond}}
+
+	resp, err := c.Get(ts.URL + "/fast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	_, err = c.Get(ts.URL + "/slow")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestGitHubAppDriver(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "app.pem")
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var auth []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v3/app/installations/5678/access_tokens" {
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"token": "installation-token", "expires_at": %q}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+			return
+		}
+		auth = append(auth, r.URL.Path+" "+r.Header.Get("Authorization"))
+	}))
+	defer ts.Close()
+
+	c, err := newDriver(clientOptions{driver: "github", endpoint: ts.URL, appID: 1234, installationID: 5678, appKeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		resp, err := c.Client.Get(ts.URL + "/api/v3/user")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	want := []string{"/api/v3/user Bearer installation-token", "/api/v3/user Bearer installation-token"}
+	if diff := cmp.Diff(want, auth); diff != "" {
+		t.Errorf("requests diff\n%s", diff)
+	}
+}
+
+func TestGitHubAppDriverErrors(t *testing.T) {
+	driverTests := []struct {
+		name    string
+		opts    clientOptions
+		wantErr string
+	}{
+		{"other driver", clientOptions{driver: "gitlab", appID: 1234}, "GitHub App authentication needs the github driver, not gitlab"},
+		{"no installation", clientOptions{driver: "github", appID: 1234, appKeyFile: "app.pem"}, "GitHub App authentication needs an installation ID and a private key file"},
+		{"missing key", clientOptions{driver: "github", appID: 1234, installationID: 5678, appKeyFile: "testdata/missing.pem"}, "failed to read the GitHub App private key: .*"},
+	}
+
+	for _, tt := range driverTests {
+		t.Run(tt.name, func(rt *testing.T) {
+			_, err := newDriver(tt.opts)
+			if !test.MatchError(rt, tt.wantErr, err) {
+				rt.Fatalf("got error %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCredentialsDriver(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	var auth []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.Header.Get("Private-Token"))
+	}))
+	defer ts.Close()
+
+	c, err := newDriver(clientOptions{driver: "gitlab", endpoint: ts.URL, tokenFile: tokenFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{"first-token", "rotated-token"} {
+		if err := ioutil.WriteFile(tokenFile, []byte(token+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.Client.Get(ts.URL + "/api/v4/user")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	if diff := cmp.Diff([]string{"first-token", "rotated-token"}, auth); diff != "" {
+		t.Errorf("requests diff\n%s", diff)
+	}
+}
+
+func TestClientOptionsSource(t *testing.T) {
+	sourceTests := []struct {
+		name    string
+		opts    clientOptions
+		want    credentials.Source
+		wantErr string
+	}{
+		{"none", clientOptions{}, nil, ""},
+		{"token", clientOptions{token: "my-token"}, credentials.Static("my-token"), ""},
+		{"file", clientOptions{tokenFile: "/secrets/token"}, credentials.File("/secrets/token"), ""},
+		{"command", clientOptions{tokenCommand: "vault read"}, &credentials.Command{Command: "vault read"}, ""},
+		{
+			"helper for the endpoint",
+			clientOptions{driver: "gitlab", endpoint: "https://gitlab.example.com/api/v4", credentialHelper: "store"},
+			&credentials.Helper{Helper: "store", URL: "https://gitlab.example.com/api/v4"},
+			"",
+		},
+		{
+			"helper for the driver",
+			clientOptions{driver: "bitbucketcloud", credentialHelper: "store"},
+			&credentials.Helper{Helper: "store", URL: "https://bitbucket.org"},
+			"",
+		},
+		{
+			"token and file",
+			clientOptions{token: "my-token", tokenFile: "/secrets/token"},
+			nil,
+			"only one of a token, a token file, a token command or a credential helper can be given",
+		},
+	}
+
+	for _, tt := range sourceTests {
+		t.Run(tt.name, func(rt *testing.T) {
+			got, err := tt.opts.source()
+			if !test.MatchError(rt, tt.wantErr, err) {
+				rt.Fatalf("got error %v, want %s", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got, cmpopts.IgnoreUnexported(credentials.Command{}, credentials.Helper{})); diff != "" {
+				rt.Errorf("source() failed diff\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestTLSAndProxy(t *testing.T) {
+	dir := t.TempDir()
+	clientCert, clientKey, clientPool := writeClientCert(t, dir)
+	var auth []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.Host+" "+r.Header.Get("Private-Token"))
+	})
+	ts := httptest.NewUnstartedServer(handler)
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientPool}
+	ts.StartTLS()
+	defer ts.Close()
+	caFile := filepath.Join(dir, "ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", ts.Certificate().Raw)
+	proxy := httptest.NewServer(handler)
+	defer proxy.Close()
+
+	tlsTests := []struct {
+		name    string
+		opts    clientOptions
+		url     string
+		want    []string
+		wantErr string
+	}{
+		{
+			"CA and client certificate",
+			clientOptions{driver: "gitlab", token: "my-token", caFile: caFile, clientCert: clientCert, clientKey: clientKey},
+			ts.URL,
+			[]string{strings.TrimPrefix(ts.URL, "https://") + " my-token"},
+			"",
+		},
+		{
+			"insecure",
+			clientOptions{driver: "gitlab", token: "my-token", insecure: true, clientCert: clientCert, clientKey: clientKey},
+			ts.URL,
+			[]string{strings.TrimPrefix(ts.URL, "https://") + " my-token"},
+			"",
+		},
+		{
+			"unknown CA",
+			clientOptions{driver: "gitlab", token: "my-token", clientCert: clientCert, clientKey: clientKey},
+			ts.URL,
+			nil,
+			".*certificate.*",
+		},
+		{
+			"proxy",
+			clientOptions{driver: "gitlab", token: "my-token", proxy: proxy.URL},
+			"http://gitlab.example.com/api/v4/user",
+			[]string{"gitlab.example.com my-token"},
+			"",
+		},
+	}
+
+	for _, tt := range tlsTests {
+		t.Run(tt.name, func(rt *testing.T) {
+			auth = nil
+			c, err := newDriver(tt.opts)
+			if err != nil {
+				rt.Fatal(err)
+			}
+
+			resp, err := c.Client.Get(tt.url)
+			if !test.MatchError(rt, tt.wantErr, err) {
+				rt.Fatalf("got error %v, want %s", err, tt.wantErr)
+			}
+			if err == nil {
+				resp.Body.Close()
+			}
+			if diff := cmp.Diff(tt.want, auth); diff != "" {
+				rt.Errorf("requests diff\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestTransportErrors(t *testing.T) {
+	transportTests := []struct {
+		name    string
+		opts    clientOptions
+		wantErr string
+	}{
+		{"missing CA file", clientOptions{caFile: "testdata/missing.pem"}, "failed to read the CA file: .*"},
+		{"invalid CA file", clientOptions{caFile: "client_test.go"}, "no PEM encoded certificates found in the CA file client_test.go"},
+		{"certificate without key", clientOptions{clientCert: "cert.pem"}, "a client certificate needs both the certificate and the key files"},
+		{"missing certificate", clientOptions{clientCert: "testdata/missing.pem", clientKey: "testdata/missing.pem"}, "failed to load the client certificate: .*"},
+		{"invalid proxy", clientOptions{proxy: "proxy.example.com"}, "invalid proxy URL \"proxy.example.com\""},
+	}
+
+	for _, tt := range transportTests {
+		t.Run(tt.name, func(rt *testing.T) {
+			_, err := tt.opts.transport()
+			if !test.MatchError(rt, tt.wantErr, err) {
+				rt.Fatalf("got error %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// writeClientCert writes a self-signed client certificate and its key to dir,
+// and returns their paths and a pool to verify it with.
+func writeClientCert(t *testing.T, dir string) (string, string, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "yaml-updater"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return certFile, keyFile, pool
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewGitClient(t *testing.T) {
+	c, err := newGitClient(clientOptions{
+		driver: "gitea", endpoint: "https://git.example.com", token: "my-token", backend: "git", sshKeyFile: "/keys/id_ed25519",
+		signingFormat: "ssh", signingKeyFile: "/keys/signing",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeGitClients()
+
+	gc, ok := c.(*gitproto.Client)
+	if !ok {
+		t.Fatalf("got a %T, want a git backend client", c)
+	}
+	want := &gitproto.Client{
+		URL:         "https://git.example.com/{repo}.git",
+		Credentials: credentials.Static("my-token"),
+		SSHKeyFile:  "/keys/id_ed25519",
+		Signing:     &gitproto.Signing{Format: "ssh", KeyFile: "/keys/signing"},
+	}
+	if diff := cmp.Diff(want, gc, cmpopts.IgnoreUnexported(gitproto.Client{}), cmpopts.IgnoreFields(gitproto.Client{}, "PullRequests")); diff != "" {
+		t.Errorf("newGitClient() failed diff\n%s", diff)
+	}
+	if gc.PullRequests == nil {
+		t.Errorf("newGitClient() did not delegate pull requests to the API")
+	}
+
+	_, err = newGitClient(clientOptions{driver: "github", backend: "svn"})
+	if !test.MatchError(t, "unknown backend svn, must be api or git", err) {
+		t.Fatalf("got error %v", err)
+	}
+
+	_, err = newGitClient(clientOptions{driver: "github", signingKeyFile: "/keys/signing"})
+	if !test.MatchError(t, "signing commits needs the git backend", err) {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+func TestNewGitClientWithGitHubApp(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "app.pem")
+	writePEM(t, keyFile, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key))
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v3/app/installations/5678/access_tokens" {
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"token": "installation-token", "expires_at": %q}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer ts.Close()
+
+	c, err := newGitClient(clientOptions{driver: "github", endpoint: ts.URL, appID: 1234, installationID: 5678, appKeyFile: keyFile, backend: "git"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeGitClients()
+
+	gc, ok := c.(*gitproto.Client)
+	if !ok {
+		t.Fatalf("got a %T, want a git backend client", c)
+	}
+	if gc.Credentials == nil {
+		t.Fatal("newGitClient() has no credentials for the GitHub App")
+	}
+	creds, err := gc.Credentials.Credentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := credentials.Credentials{Username: "x-access-token", Token: "installation-token"}
+	if diff := cmp.Diff(want, creds); diff != "" {
+		t.Errorf("credentials diff\n%s", diff)
+	}
+}
+
+func TestAPIClientFileErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message": "sha does not match"}`, http.StatusUnprocessableEntity)
+	}))
+	defer ts.Close()
+	c, err := newGitClient(clientOptions{driver: "github", endpoint: ts.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.UpdateFile(context.Background(), "my-org/my-project", "main", "values.yaml", "update", "abc123", scm.Signature{}, []byte("a: 1\n"))
+
+	var e client.SCMError
+	if !errors.As(err, &e) || e.Status != http.StatusUnprocessableEntity {
+		t.Fatalf("got error %v, want a 422 SCMError", err)
+	}
+	if !test.MatchError(t, "failed to update file values.yaml in repo my-org/my-project branch main: sha does not match", err) {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+func TestCloneURL(t *testing.T) {
+	urlTests := []struct {
+		opts clientOptions
+		want string
+	}{
+		{clientOptions{driver: "github"}, "https://github.com/{repo}.git"},
+		{clientOptions{driver: "gitlab", endpoint: "https://gitlab.example.com/api/v4"}, "https://gitlab.example.com/{repo}.git"},
+		{clientOptions{driver: "gitea", gitURL: "ssh://git@git.example.com:2222/{repo}.git"}, "ssh://git@git.example.com:2222/{repo}.git"},
+	}
+
+	for _, tt := range urlTests {
+		got, err := tt.opts.cloneURL()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("cloneURL() got %q, want %q", got, tt.want)
+		}
+	}
+}
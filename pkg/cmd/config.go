@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+
+	"github.com/ocraviotto/pkg/client"
+	"github.com/ocraviotto/yaml-updater/pkg/config"
+)
+
+// configPaths returns the comma separated paths given with --config-path.
+func configPaths() []string {
+	return splitList(viper.GetString(configPathFlag))
+}
+
+// splitList returns the non empty items of the comma separated list s.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// configExists returns true if any of the paths given with --config-path
+// exists, or is in a git repository. Without any, the update command uses the
+// flags instead.
+func configExists() bool {
+	for _, p := range configPaths() {
+		if strings.HasPrefix(p, config.RemotePrefix) {
+			return true
+		}
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadConfig loads and merges the repositories configuration from the paths
+// given with --config-path, fetching the repo:// ones with the Git service.
+func loadConfig(ctx context.Context, strict bool) (*config.RepoConfiguration, error) {
+	l := config.Loader{
+		Strict:            strict,
+		AllowOverrides:    viper.GetBool(configOverrideFlag),
+		Fetch:             fetchFromViper(ctx),
+		RemoteEnv:         splitList(viper.GetString(configRemoteEnvFlag)),
+		RemoteConnections: viper.GetBool(configRemoteConnectionsFlag),
+	}
+	return l.Load(configPaths()...)
+}
+
+// fetchFromViper returns a config.FetchFunc that reads files with the git
+// driver created from the flags, once it is first needed.
+func fetchFromViper(ctx context.Context) config.FetchFunc {
+	var c client.GitClient
+	return func(repo, ref, path string) ([]byte, error) {
+		if c == nil {
+			var err error
+			if c, err = createGitClientFromViper(); err != nil {
+				return nil, err
+			}
+		}
+		content, err := c.GetFile(ctx, repo, ref, path)
+		if err != nil {
+			return nil, err
+		}
+		return content.Data, nil
+	}
+}
+
+// selectedKeys returns the keys of the repositories in configs selected with
+// --only and --selector, and whether any of them was given. Glob patterns in
+// --only are expanded to the matching keys, while any other key is returned
+// as is, whether it exists or not.
+func selectedKeys(configs *config.RepoConfiguration) ([]string, bool, error) {
+	onlyVal, selectorVal := viper.GetString(onlyFlag), viper.GetString(selectorFlag)
+	if onlyVal == "" && selectorVal == "" {
+		return nil, false, nil
+	}
+	var keys []string
+	if onlyVal != "" {
+		var err error
+		keys, err = expandKeys(configs, strings.Split(onlyVal, ","))
+		if err != nil {
+			return nil, true, err
+		}
+	}
+	if selectorVal == "" {
+		return keys, true, nil
+	}
+	sel, err := config.ParseSelector(selectorVal)
+	if err != nil {
+		return nil, true, err
+	}
+	selected := configs.Select(sel)
+	if onlyVal != "" {
+		selected = intersect(keys, selected)
+	}
+	if len(selected) == 0 {
+		return nil, true, fmt.Errorf("no repository in the current repositories config matches the selection")
+	}
+	return selected, true, nil
+}
+
+// expandKeys replaces the glob patterns in keys with the repository keys in
+// configs that match them, failing if a pattern matches none.
+func expandKeys(configs *config.RepoConfiguration, keys []string) ([]string, error) {
+	var expanded []string
+	seen := map[string]bool{}
+	for _, key := range keys {
+		matches := []string{key}
+		if strings.ContainsAny(key, "*?[") {
+			var err error
+			matches, err = configs.Match(key)
+			if err != nil {
+				return nil, err
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("user given pattern: %s does not match any repository in the current repositories config", key)
+			}
+		}
+		for _, m := range matches {
+			if !seen[m] {
+				seen[m] = true
+				expanded = append(expanded, m)
+			}
+		}
+	}
+	return expanded, nil
+}
+
+func intersect(a, b []string) []string {
+	in := map[string]bool{}
+	for _, s := range b {
+		in[s] = true
+	}
+	var both []string
+	for _, s := range a {
+		if in[s] {
+			both = append(both, s)
+		}
+	}
+	return both
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/ocraviotto/yaml-updater/pkg/applier"
+)
+
+// Exit codes of a failed command.
+const (
+	// exitFailure is any failure, including all the repositories failing to
+	// update.
+	exitFailure = 1
+	// exitPartialFailure is some repositories failing to update while others
+	// were updated.
+	exitPartialFailure = 2
+	// exitNothingToDo is no repository to update after applying the
+	// configuration and flags.
+	exitNothingToDo = 3
+)
+
+// exitError is an error that makes the command exit with code.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// exitCode returns the code the command should exit with for err.
+func exitCode(err error) int {
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+	var updateErr *applier.UpdateError
+	if errors.As(err, &updateErr) && updateErr.Partial() {
+		return exitPartialFailure
+	}
+	return exitFailure
+}
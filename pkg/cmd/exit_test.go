@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ocraviotto/yaml-updater/pkg/applier"
+)
+
+func TestExitCode(t *testing.T) {
+	failed := applier.Result{Key: "dev", Status: applier.StatusFailed, Err: errors.New("failure")}
+	exitTests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"error", errors.New("failure"), exitFailure},
+		{
+			"all failed",
+			&applier.UpdateError{Results: []applier.Result{failed, {Key: "prod", Status: applier.StatusSkipped}}},
+			exitFailure,
+		},
+		{
+			"some failed",
+			fmt.Errorf("wrapped: %w", &applier.UpdateError{Results: []applier.Result{failed, {Key: "prod", Status: applier.StatusUpdated}}}),
+			exitPartialFailure,
+		},
+		{"nothing to do", &exitError{code: exitNothingToDo, err: errors.New("nothing")}, exitNothingToDo},
+	}
+
+	for _, tt := range exitTests {
+		t.Run(tt.name, func(rt *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				rt.Fatalf("exitCode(%v) got %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
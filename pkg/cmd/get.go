@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/go-logr/zapr"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/ocraviotto/yaml-updater/pkg/applier"
+	"github.com/ocraviotto/yaml-updater/pkg/config"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputText  = "text"
+)
+
+// currentValue is the value at the updateKey of a repository configuration.
+type currentValue struct {
+	Key        string `json:"key"`
+	SourceRepo string `json:"sourceRepo"`
+	Branch     string `json:"sourceBranch"`
+	FilePath   string `json:"filePath"`
+	UpdateKey  string `json:"updateKey"`
+	Value      string `json:"value"`
+	Error      string `json:"error,omitempty"`
+}
+
+func makeGetCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "get",
+		Short: "get the current values from the repository configurations",
+		Long: "Fetches the filePath of each enabled repository configuration at its sourceBranch " +
+			"and prints the value at its updateKey",
+		PreRun: func(cmd *cobra.Command, args []string) {
+			// Bound when run, as other commands have an output flag too.
+			logIfError(viper.BindPFlag("output", cmd.Flags().Lookup("output")))
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger := newLogger()
+			l := zapr.NewLogger(logger)
+			defer func() {
+				_ = logger.Sync() // flushes buffer, if any
+			}()
+			output := viper.GetString("output")
+			if output != outputTable && output != outputJSON && output != outputText {
+				return fmt.Errorf("unknown output format %q, must be one of %s, %s or %s", output, outputTable, outputJSON, outputText)
+			}
+			ctx, cancel := commandContext(cmd)
+			defer cancel()
+			repositories, err := loadConfig(ctx, false)
+			if err != nil {
+				return fmt.Errorf("failed to read repositories yaml config from file: %w", err)
+			}
+			if err := selectRepositories(repositories); err != nil {
+				return err
+			}
+			applier, err := newApplier(l, repositories)
+			if err != nil {
+				return err
+			}
+			values, failed := currentValues(ctx, applier, repositories)
+			if err := printValues(cmd.OutOrStdout(), output, values); err != nil {
+				return err
+			}
+			if failed > 0 {
+				return fmt.Errorf("failed to get the value of %d repositories", failed)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringP(
+		"output",
+		"o",
+		outputTable,
+		"Output format, one of table, json or text",
+	)
+
+	return cmd
+}
+
+// selectRepositories keeps the repositories given with --only or --selector,
+// if any, and otherwise all the repositories that are not disabled.
+func selectRepositories(configs *config.RepoConfiguration) error {
+	only, ok, err := selectedKeys(configs)
+	if err != nil {
+		return err
+	}
+	if ok {
+		for _, repo := range only {
+			if configs.Find(repo) == nil {
+				return fmt.Errorf("user given repository: %s does not exist in the current repositories config", repo)
+			}
+		}
+		applyOnly(configs, only)
+	}
+	for k, r := range configs.Repositories {
+		if r.Disabled {
+			delete(configs.Repositories, k)
+		}
+	}
+	return nil
+}
+
+// currentValues reads the value of every repository in configs, sorted by
+// key, and returns them along the number of failed reads.
+func currentValues(ctx context.Context, a *applier.Applier, configs *config.RepoConfiguration) ([]currentValue, int) {
+	var failed int
+	keys := configs.Keys()
+	values := make([]currentValue, 0, len(keys))
+	for _, key := range keys {
+		repo := configs.Repositories[key]
+		v := currentValue{
+			Key:        key,
+			SourceRepo: repo.SourceRepo,
+			Branch:     repo.SourceBranch,
+			FilePath:   repo.FilePath,
+			UpdateKey:  repo.UpdateKey,
+		}
+		value, err := a.CurrentValue(ctx, repo)
+		if err != nil {
+			v.Error = err.Error()
+			failed++
+		}
+		v.Value = value
+		values = append(values, v)
+	}
+	return values, failed
+}
+
+func printValues(w io.Writer, output string, values []currentValue) error {
+	switch output {
+	case outputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(values)
+	case outputText:
+		for _, v := range values {
+			if v.Error != "" {
+				fmt.Fprintf(w, "%s error: %s\n", v.Key, v.Error)
+				continue
+			}
+			fmt.Fprintf(w, "%s=%s\n", v.Key, v.Value)
+		}
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tREPOSITORY\tBRANCH\tFILE\tUPDATE KEY\tVALUE")
+	for _, v := range values {
+		value := v.Value
+		if v.Error != "" {
+			value = "error: " + v.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", v.Key, v.SourceRepo, v.Branch, v.FilePath, v.UpdateKey, value)
+	}
+	return tw.Flush()
+}
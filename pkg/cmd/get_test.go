@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPrintValues(t *testing.T) {
+	values := []currentValue{
+		{
+			Key:        "testRepo1",
+			SourceRepo: "my-org/my-project",
+			Branch:     "main",
+			FilePath:   "service-a/deployment.yaml",
+			UpdateKey:  "spec.template.spec.containers.0.image",
+			Value:      "quay.io/myorg/my-image:v1.1.0",
+		},
+		{
+			Key:        "testRepo2",
+			SourceRepo: "my-org/my-other-project",
+			Branch:     "master",
+			FilePath:   "service-b/pod.yaml",
+			UpdateKey:  "spec.containers.0.image",
+			Error:      "not found",
+		},
+	}
+	printTests := []struct {
+		output string
+		want   string
+	}{
+		{
+			outputTable,
+			"KEY        REPOSITORY               BRANCH  FILE                       UPDATE KEY                             VALUE\n" +
+				"testRepo1  my-org/my-project        main    service-a/deployment.yaml  spec.template.spec.containers.0.image  quay.io/myorg/my-image:v1.1.0\n" +
+				"testRepo2  my-org/my-other-project  master  service-b/pod.yaml         spec.containers.0.image                error: not found\n",
+		},
+		{
+			outputText,
+			"testRepo1=quay.io/myorg/my-image:v1.1.0\ntestRepo2 error: not found\n",
+		},
+		{
+			outputJSON,
+			`[
+  {
+    "key": "testRepo1",
+    "sourceRepo": "my-org/my-project",
+    "sourceBranch": "main",
+    "filePath": "service-a/deployment.yaml",
+    "updateKey": "spec.template.spec.containers.0.image",
+    "value": "quay.io/myorg/my-image:v1.1.0"
+  },
+  {
+    "key": "testRepo2",
+    "sourceRepo": "my-org/my-other-project",
+    "sourceBranch": "master",
+    "filePath": "service-b/pod.yaml",
+    "updateKey": "spec.containers.0.image",
+    "value": "",
+    "error": "not found"
+  }
+]
+`,
+		},
+	}
+
+	for _, tt := range printTests {
+		t.Run(tt.output, func(rt *testing.T) {
+			var b bytes.Buffer
+			if err := printValues(&b, tt.output, values); err != nil {
+				rt.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, b.String()); diff != "" {
+				rt.Errorf("printValues(%s) failed diff\n%s", tt.output, diff)
+			}
+		})
+	}
+}
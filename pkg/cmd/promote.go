@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/go-logr/zapr"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func makePromoteCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "promote",
+		Short: "copy the current value from a repository configuration to another",
+		Long: "Reads the current value at the updateKey of the --from repository configuration, in its filePath and sourceBranch, " +
+			"and applies it to the --to repository configuration, as update would do with --new-value",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger := newLogger()
+			l := zapr.NewLogger(logger)
+			defer func() {
+				_ = logger.Sync() // flushes buffer, if any
+			}()
+			from, to := viper.GetString("from"), viper.GetString("to")
+			if from == "" || to == "" {
+				return fmt.Errorf("both --from and --to repository keys are required")
+			}
+			ctx, cancel := commandContext(cmd)
+			defer cancel()
+			repositories, err := loadConfig(ctx, false)
+			if err != nil {
+				return fmt.Errorf("failed to read repositories yaml config from file: %w", err)
+			}
+			fromCfg, toCfg := repositories.Find(from), repositories.Find(to)
+			if fromCfg == nil {
+				return fmt.Errorf("repository %s does not exist in the current repositories config", from)
+			}
+			if toCfg == nil {
+				return fmt.Errorf("repository %s does not exist in the current repositories config", to)
+			}
+			applier, err := newApplier(l, repositories)
+			if err != nil {
+				return err
+			}
+			value, err := applier.CurrentValue(ctx, fromCfg)
+			if err != nil {
+				return err
+			}
+			// the promoted value wins over the one set or read by the entry
+			toCfg.Value, toCfg.ValueFrom = "", ""
+			l.Info("promoting value", "from", from, "to", to, "value", value)
+			return applier.UpdateRepositoryByKey(ctx, to, value)
+		},
+	}
+
+	cmd.Flags().String(
+		"from",
+		"",
+		"Key of the repository in configuration to read the current value from",
+	)
+	logIfError(viper.BindPFlag("from", cmd.Flags().Lookup("from")))
+
+	cmd.Flags().String(
+		"to",
+		"",
+		"Key of the repository in configuration to apply the value to",
+	)
+	logIfError(viper.BindPFlag("to", cmd.Flags().Lookup("to")))
+
+	return cmd
+}
@@ -1,19 +1,56 @@
 package cmd
 
 import (
+	"context"
 	"log"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/ocraviotto/yaml-updater/pkg/credentials"
+	"github.com/ocraviotto/yaml-updater/pkg/retry"
 )
 
 const (
-	driverFlag      = "driver"
-	apiEndpointFlag = "api-endpoint"
-	authTokenFlag   = "auth-token"
-	usernameFlag    = "username"
-	insecureFlag    = "insecure"
+	driverFlag                  = "driver"
+	apiEndpointFlag             = "api-endpoint"
+	authTokenFlag               = "auth-token"
+	usernameFlag                = "username"
+	authTokenFileFlag           = "auth-token-file"
+	authTokenCommandFlag        = "auth-token-command"
+	credentialHelperFlag        = "credential-helper"
+	insecureFlag                = "insecure"
+	caFileFlag                  = "ca-file"
+	clientCertFlag              = "client-cert"
+	clientKeyFlag               = "client-key"
+	proxyFlag                   = "proxy"
+	backendFlag                 = "backend"
+	gitURLFlag                  = "git-url"
+	sshKeyFileFlag              = "ssh-key-file"
+	knownHostsFileFlag          = "ssh-known-hosts-file"
+	signingFormatFlag           = "signing-format"
+	signingKeyFileFlag          = "signing-key-file"
+	signingKeyFlag              = "signing-key"
+	appIDFlag                   = "github-app-id"
+	installationIDFlag          = "github-app-installation-id"
+	appKeyFileFlag              = "github-app-private-key-file"
+	configPathFlag              = "config-path"
+	configOverrideFlag          = "config-override"
+	configRemoteEnvFlag         = "config-remote-env"
+	configRemoteConnectionsFlag = "config-remote-connections"
+	onlyFlag                    = "only"
+	selectorFlag                = "selector"
+	retryAttemptsFlag           = "retry-attempts"
+	retryTimeoutFlag            = "retry-timeout"
+	timeoutFlag                 = "timeout"
+	requestTimeoutFlag          = "request-timeout"
 )
 
 var (
@@ -52,6 +89,29 @@ func makeRootCmd() *cobra.Command {
 		"The token or password to authenticate requests to your Git service",
 	)
 	logIfError(viper.BindPFlag(authTokenFlag, cmd.PersistentFlags().Lookup(authTokenFlag)))
+
+	cmd.PersistentFlags().String(
+		authTokenFileFlag,
+		"",
+		"Path to a file holding the token, instead of --auth-token. It is read again each time the token is needed, so it can be rotated",
+	)
+	logIfError(viper.BindPFlag(authTokenFileFlag, cmd.PersistentFlags().Lookup(authTokenFileFlag)))
+
+	cmd.PersistentFlags().String(
+		authTokenCommandFlag,
+		"",
+		"Shell command printing the token, instead of --auth-token",
+	)
+	logIfError(viper.BindPFlag(authTokenCommandFlag, cmd.PersistentFlags().Lookup(authTokenCommandFlag)))
+
+	cmd.PersistentFlags().String(
+		credentialHelperFlag,
+		"",
+		"Git credential helper giving the token as password, instead of --auth-token, e.g. store, /path/to/helper or '!command'. "+
+			"It is asked for the credentials of the host of --api-endpoint, or of the public service of the driver",
+	)
+	logIfError(viper.BindPFlag(credentialHelperFlag, cmd.PersistentFlags().Lookup(credentialHelperFlag)))
+
 	cmd.PersistentFlags().String(
 		usernameFlag,
 		"",
@@ -73,7 +133,193 @@ func makeRootCmd() *cobra.Command {
 	)
 	logIfError(viper.BindPFlag(insecureFlag, cmd.PersistentFlags().Lookup(insecureFlag)))
 
+	cmd.PersistentFlags().String(
+		caFileFlag,
+		"",
+		"Path to a PEM encoded bundle of CA certificates to verify your Git service with, in addition to the system ones",
+	)
+	logIfError(viper.BindPFlag(caFileFlag, cmd.PersistentFlags().Lookup(caFileFlag)))
+
+	cmd.PersistentFlags().String(
+		clientCertFlag,
+		"",
+		"Path to a PEM encoded client certificate to authenticate to your Git service with. Needs --client-key",
+	)
+	logIfError(viper.BindPFlag(clientCertFlag, cmd.PersistentFlags().Lookup(clientCertFlag)))
+
+	cmd.PersistentFlags().String(
+		clientKeyFlag,
+		"",
+		"Path to the PEM encoded key of the --client-cert certificate",
+	)
+	logIfError(viper.BindPFlag(clientKeyFlag, cmd.PersistentFlags().Lookup(clientKeyFlag)))
+
+	cmd.PersistentFlags().String(
+		proxyFlag,
+		"",
+		"URL of the proxy for the requests to your Git service. By default the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables are used",
+	)
+	logIfError(viper.BindPFlag(proxyFlag, cmd.PersistentFlags().Lookup(proxyFlag)))
+
+	cmd.PersistentFlags().String(
+		backendFlag,
+		"api",
+		"How files are read and committed: api, with the API of your Git service, or git, with shallow clones over HTTPS or SSH, "+
+			"for services without a usable contents API. With git, the API is only used to create pull requests",
+	)
+	logIfError(viper.BindPFlag(backendFlag, cmd.PersistentFlags().Lookup(backendFlag)))
+
+	cmd.PersistentFlags().String(
+		gitURLFlag,
+		"",
+		"URL to clone the repositories from with the git backend, where {repo} is replaced with the repository name, "+
+			"e.g. ssh://git@git.example.com/{repo}.git. By default the HTTPS URL of the host of --api-endpoint",
+	)
+	logIfError(viper.BindPFlag(gitURLFlag, cmd.PersistentFlags().Lookup(gitURLFlag)))
+
+	cmd.PersistentFlags().String(
+		sshKeyFileFlag,
+		"",
+		"Path to the private key to authenticate to SSH URLs with, for the git backend",
+	)
+	logIfError(viper.BindPFlag(sshKeyFileFlag, cmd.PersistentFlags().Lookup(sshKeyFileFlag)))
+
+	cmd.PersistentFlags().String(
+		knownHostsFileFlag,
+		"",
+		"Path to the known_hosts file to verify SSH servers with, for the git backend",
+	)
+	logIfError(viper.BindPFlag(knownHostsFileFlag, cmd.PersistentFlags().Lookup(knownHostsFileFlag)))
+
+	cmd.PersistentFlags().String(
+		signingFormatFlag,
+		"gpg",
+		"Format of the key to sign the commits with, for the git backend: gpg or ssh",
+	)
+	logIfError(viper.BindPFlag(signingFormatFlag, cmd.PersistentFlags().Lookup(signingFormatFlag)))
+
+	cmd.PersistentFlags().String(
+		signingKeyFileFlag,
+		"",
+		"Path to the private key to sign the commits with, without a passphrase: an ASCII armored GPG secret key or an SSH private key. "+
+			"Needs --backend git",
+	)
+	logIfError(viper.BindPFlag(signingKeyFileFlag, cmd.PersistentFlags().Lookup(signingKeyFileFlag)))
+
+	cmd.PersistentFlags().String(
+		signingKeyFlag,
+		"",
+		"The private key to sign the commits with, instead of --signing-key-file, best set with GIT_SIGNING_KEY",
+	)
+	logIfError(viper.BindPFlag(signingKeyFlag, cmd.PersistentFlags().Lookup(signingKeyFlag)))
+
+	cmd.PersistentFlags().Int64(
+		appIDFlag,
+		0,
+		"ID of a GitHub App to authenticate as, instead of with --auth-token. Needs --github-app-installation-id and --github-app-private-key-file",
+	)
+	logIfError(viper.BindPFlag(appIDFlag, cmd.PersistentFlags().Lookup(appIDFlag)))
+
+	cmd.PersistentFlags().Int64(
+		installationIDFlag,
+		0,
+		"ID of the installation of the GitHub App in the organization or user owning the repositories",
+	)
+	logIfError(viper.BindPFlag(installationIDFlag, cmd.PersistentFlags().Lookup(installationIDFlag)))
+
+	cmd.PersistentFlags().String(
+		appKeyFileFlag,
+		"",
+		"Path to the PEM encoded private key of the GitHub App",
+	)
+	logIfError(viper.BindPFlag(appKeyFileFlag, cmd.PersistentFlags().Lookup(appKeyFileFlag)))
+
+	cmd.PersistentFlags().String(
+		configPathFlag,
+		".yaml-updater.yaml",
+		"List of repository configurations to apply update to. If file exists, it takes precedence over repository details passed via flags. "+
+			"Several comma separated files or directories can be given, and their repositories are merged. "+
+			"A file in a git repository can be given as repo://org/repo@branch:path",
+	)
+	logIfError(viper.BindPFlag(configPathFlag, cmd.PersistentFlags().Lookup(configPathFlag)))
+
+	cmd.PersistentFlags().Bool(
+		configOverrideFlag,
+		false,
+		"When merging several repository configurations, let a repository loaded later override one with the same key, instead of failing",
+	)
+	logIfError(viper.BindPFlag(configOverrideFlag, cmd.PersistentFlags().Lookup(configOverrideFlag)))
+
+	cmd.PersistentFlags().String(
+		configRemoteEnvFlag,
+		"",
+		"Comma separated list of the environment variables that configurations loaded from a git repository can reference with ${VAR}. "+
+			"Any other reference in them, including ${file:...}, fails, so that the secrets of the pipeline can not leak through a shared configuration",
+	)
+	logIfError(viper.BindPFlag(configRemoteEnvFlag, cmd.PersistentFlags().Lookup(configRemoteEnvFlag)))
+
+	cmd.PersistentFlags().Bool(
+		configRemoteConnectionsFlag,
+		false,
+		"Let configurations loaded from a git repository set connections, which otherwise fail to load, as a connection can run commands, read local files and send the tokens of the pipeline to any endpoint",
+	)
+	logIfError(viper.BindPFlag(configRemoteConnectionsFlag, cmd.PersistentFlags().Lookup(configRemoteConnectionsFlag)))
+
+	cmd.PersistentFlags().String(
+		onlyFlag,
+		"",
+		"A single or comman separated list of keys, or glob patterns of keys, of the repositories defined in configuration to update and use. By default all are enabled "+
+			"unless they are explicitely disabled. If given, any repository not in the only list will be disabled and if in the list, enabled. "+
+			"This is why it takes precedence over the repositories 'disabled' field. "+
+			"NOTE: This is different than the override keys in that it will disable any repository not in the list",
+	)
+	logIfError(viper.BindPFlag(onlyFlag, cmd.PersistentFlags().Lookup(onlyFlag)))
+
+	cmd.PersistentFlags().String(
+		selectorFlag,
+		"",
+		"Comma separated label requirements selecting the repositories defined in configuration to update and use, "+
+			"e.g. env=prod,team!=search,tier in (web,api). Values can be glob patterns. "+
+			"It works as --only does, and when both are given only the repositories selected by both are used",
+	)
+	logIfError(viper.BindPFlag(selectorFlag, cmd.PersistentFlags().Lookup(selectorFlag)))
+
+	cmd.PersistentFlags().Int(
+		retryAttemptsFlag,
+		retry.DefaultBackoff.Attempts,
+		"Maximum attempts, with exponential backoff, for requests to the Git service that are rate limited or fail with a server error, "+
+			"and for file updates that conflict with a concurrent change. 1 disables retries",
+	)
+	logIfError(viper.BindPFlag(retryAttemptsFlag, cmd.PersistentFlags().Lookup(retryAttemptsFlag)))
+
+	cmd.PersistentFlags().Duration(
+		retryTimeoutFlag,
+		retry.DefaultBackoff.Timeout,
+		"Maximum time spent retrying a request or file update",
+	)
+	logIfError(viper.BindPFlag(retryTimeoutFlag, cmd.PersistentFlags().Lookup(retryTimeoutFlag)))
+
+	cmd.PersistentFlags().Duration(
+		timeoutFlag,
+		0,
+		"Maximum time for the whole command, e.g. 10m, after which the updates in progress are cancelled and the rest are not started. 0 means no limit",
+	)
+	logIfError(viper.BindPFlag(timeoutFlag, cmd.PersistentFlags().Lookup(timeoutFlag)))
+
+	cmd.PersistentFlags().Duration(
+		requestTimeoutFlag,
+		time.Minute,
+		"Maximum time for each request to the Git service. 0 means no limit",
+	)
+	logIfError(viper.BindPFlag(requestTimeoutFlag, cmd.PersistentFlags().Lookup(requestTimeoutFlag)))
+
 	cmd.AddCommand(makeUpdateCmd())
+	cmd.AddCommand(makePromoteCmd())
+	cmd.AddCommand(makeGetCmd())
+	cmd.AddCommand(makeCleanupCmd())
+	cmd.AddCommand(makeStatusCmd())
+	cmd.AddCommand(makeValidateCmd())
+	cmd.AddCommand(makeSchemaCmd())
 
 	return cmd
 }
@@ -84,9 +330,51 @@ func initConfig() {
 	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
 }
 
-// Execute is the main entry point into this component.
+// Execute is the main entry point into this component. It exits with 1 on
+// failure, 2 when only some repositories failed to update and 3 when there
+// are no repositories to update.
+//
+// On SIGINT or SIGTERM the context of the command is cancelled, so that the
+// updates in progress stop and the rest are not started. A second signal
+// terminates the process.
 func Execute() {
-	if err := makeRootCmd().Execute(); err != nil {
-		log.Fatal(err)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	log.SetOutput(credentials.RedactWriter(os.Stderr))
+	cmd := makeRootCmd()
+	cmd.SetErr(credentials.RedactWriter(os.Stderr))
+	err := cmd.ExecuteContext(ctx)
+	stop()
+	closeGitClients()
+	if err != nil {
+		log.Print(err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// newLogger returns a production logger that redacts the credentials from its
+// output.
+func newLogger() *zap.Logger {
+	cfg := zap.NewProductionConfig()
+	core := zapcore.NewCore(
+		zapcore.NewJSONEncoder(cfg.EncoderConfig),
+		zapcore.Lock(zapcore.AddSync(credentials.RedactWriter(os.Stderr))),
+		cfg.Level,
+	)
+	return zap.New(core, zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel))
+}
+
+// commandContext returns the context of cmd limited by --timeout, if set.
+func commandContext(cmd *cobra.Command) (context.Context, context.CancelFunc) {
+	ctx := cmd.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if d := viper.GetDuration(timeoutFlag); d > 0 {
+		return context.WithTimeout(ctx, d)
 	}
+	return context.WithCancel(ctx)
 }
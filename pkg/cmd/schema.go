@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ocraviotto/yaml-updater/pkg/config"
+)
+
+func makeSchemaCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "schema",
+		Short: "print the JSON Schema of the repositories configuration",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			b, err := json.MarshalIndent(config.JSONSchema(), "", "  ")
+			if err != nil {
+				return fmt.Errorf("failed to marshal the JSON Schema: %w", err)
+			}
+			_, err = fmt.Fprintln(cmd.OutOrStdout(), string(b))
+			return err
+		},
+	}
+}
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/ocraviotto/yaml-updater/pkg/tracking"
+)
+
+func makeStatusCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "show the PRs created by previous runs",
+		Long: "Finds the PRs created for each enabled repository configuration, by the key marked in their body, " +
+			"or for PRs without a marker, by the branchGenerateName prefix of their branch, " +
+			"and shows their state, the combined state of the commit statuses and, with GitHub, the check runs of the open ones and their age",
+		PreRun: func(cmd *cobra.Command, args []string) {
+			// Bound when run, as other commands have an output flag too.
+			logIfError(viper.BindPFlag("output", cmd.Flags().Lookup("output")))
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			output := viper.GetString("output")
+			if output != outputTable && output != outputJSON {
+				return fmt.Errorf("unknown output format %q, must be one of %s or %s", output, outputTable, outputJSON)
+			}
+			state := viper.GetString("state")
+			switch state {
+			case "all":
+				state = ""
+			case "open", "merged", "closed":
+			default:
+				return fmt.Errorf("unknown state %q, must be one of all, open, merged or closed", state)
+			}
+			since, err := parseAge(viper.GetString("since"))
+			if err != nil {
+				return err
+			}
+			ctx, cancel := commandContext(cmd)
+			defer cancel()
+			repositories, err := loadConfig(ctx, false)
+			if err != nil {
+				return fmt.Errorf("failed to read repositories yaml config from file: %w", err)
+			}
+			if err := selectRepositories(repositories); err != nil {
+				return err
+			}
+			services, err := trackingServices(repositories)
+			if err != nil {
+				return err
+			}
+			opts := tracking.StatusOptions{State: state, Since: since}
+			var (
+				statuses []tracking.PullRequestStatus
+				failed   int
+			)
+			for _, t := range tracking.Targets(repositories) {
+				prs, err := tracking.PullRequests(ctx, services[t.Connection], t, opts)
+				if err != nil {
+					prs = []tracking.PullRequestStatus{{Keys: t.Keys, Repo: t.Repo, Error: err.Error()}}
+				}
+				for _, pr := range prs {
+					if pr.Error != "" {
+						failed++
+					}
+				}
+				statuses = append(statuses, prs...)
+			}
+			if err := printPullRequestStatuses(cmd.OutOrStdout(), output, statuses); err != nil {
+				return err
+			}
+			if failed > 0 {
+				return fmt.Errorf("failed to get the status of %d pull requests or repositories", failed)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String(
+		"state",
+		"all",
+		"Show only the PRs in this state, one of all, open, merged or closed",
+	)
+	logIfError(viper.BindPFlag("state", cmd.Flags().Lookup("state")))
+
+	cmd.Flags().String(
+		"since",
+		"",
+		"Show only the PRs created within this time, e.g. 7d or 12h. All of them by default",
+	)
+	logIfError(viper.BindPFlag("since", cmd.Flags().Lookup("since")))
+
+	cmd.Flags().StringP(
+		"output",
+		"o",
+		outputTable,
+		"Output format, one of table or json",
+	)
+
+	return cmd
+}
+
+func printPullRequestStatuses(w io.Writer, output string, statuses []tracking.PullRequestStatus) error {
+	if output == outputJSON {
+		if statuses == nil {
+			statuses = []tracking.PullRequestStatus{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(statuses)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEYS\tREPOSITORY\tPR\tSTATE\tCHECKS\tAGE\tLINK")
+	for _, s := range statuses {
+		keys := strings.Join(s.Keys, ",")
+		if s.Number == 0 {
+			fmt.Fprintf(tw, "%s\t%s\t\terror: %s\t\t\t\n", keys, s.Repo, s.Error)
+			continue
+		}
+		checks := s.Checks
+		if s.Error != "" {
+			checks = "error: " + s.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t#%d\t%s\t%s\t%s\t%s\n", keys, s.Repo, s.Number, s.State, checks, s.Age, s.Link)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ocraviotto/yaml-updater/pkg/tracking"
+)
+
+func TestPrintPullRequestStatuses(t *testing.T) {
+	created := time.Date(2021, time.March, 30, 12, 0, 0, 0, time.UTC)
+	statuses := []tracking.PullRequestStatus{
+		{Keys: []string{"testRepo1"}, Repo: "my-org/my-project", Number: 12, Title: "Update", Branch: "gitops-aBcDe", Link: "https://github.com/my-org/my-project/pull/12", State: "open", Checks: tracking.ChecksFailure, Created: &created, Age: "3h"},
+		{Keys: []string{"testRepo1"}, Repo: "my-org/my-project", Number: 10, Title: "Update", Branch: "gitops-FgHiJ", Link: "https://github.com/my-org/my-project/pull/10", State: "merged", Created: &created, Age: "5d"},
+		{Keys: []string{"testRepo2"}, Repo: "my-org/my-other-project", Error: "not found"},
+	}
+	printTests := []struct {
+		output string
+		want   string
+	}{
+		{
+			outputTable,
+			"KEYS       REPOSITORY               PR   STATE             CHECKS   AGE  LINK\n" +
+				"testRepo1  my-org/my-project        #12  open              failure  3h   https://github.com/my-org/my-project/pull/12\n" +
+				"testRepo1  my-org/my-project        #10  merged                     5d   https://github.com/my-org/my-project/pull/10\n" +
+				"testRepo2  my-org/my-other-project       error: not found                \n",
+		},
+		{
+			outputJSON,
+			`[
+  {
+    "keys": [
+      "testRepo1"
+    ],
+    "sourceRepo": "my-org/my-project",
+    "number": 12,
+    "title": "Update",
+    "branch": "gitops-aBcDe",
+    "link": "https://github.com/my-org/my-project/pull/12",
+    "state": "open",
+    "checks": "failure",
+    "created": "2021-03-30T12:00:00Z",
+    "age": "3h"
+  },
+  {
+    "keys": [
+      "testRepo1"
+    ],
+    "sourceRepo": "my-org/my-project",
+    "number": 10,
+    "title": "Update",
+    "branch": "gitops-FgHiJ",
+    "link": "https://github.com/my-org/my-project/pull/10",
+    "state": "merged",
+    "created": "2021-03-30T12:00:00Z",
+    "age": "5d"
+  },
+  {
+    "keys": [
+      "testRepo2"
+    ],
+    "sourceRepo": "my-org/my-other-project",
+    "error": "not found"
+  }
+]
+`,
+		},
+	}
+
+	for _, tt := range printTests {
+		t.Run(tt.output, func(rt *testing.T) {
+			var b bytes.Buffer
+			if err := printPullRequestStatuses(&b, tt.output, statuses); err != nil {
+				rt.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, b.String()); diff != "" {
+				rt.Errorf("printPullRequestStatuses(%s) failed diff\n%s", tt.output, diff)
+			}
+		})
+	}
+}
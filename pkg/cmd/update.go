@@ -1,16 +1,13 @@
 package cmd
 
 import (
-	"context"
 	"fmt"
 	"strings"
 
 	"github.com/go-logr/zapr"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"go.uber.org/zap"
 
-	"github.com/ocraviotto/pkg/client"
 	"github.com/ocraviotto/yaml-updater/pkg/applier"
 	"github.com/ocraviotto/yaml-updater/pkg/config"
 )
@@ -20,38 +17,57 @@ func makeUpdateCmd() *cobra.Command {
 		Use:   "update",
 		Short: "update a repository configuration",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			var repositories, pRepositories *config.RepoConfiguration
-			logger, _ := zap.NewProduction()
+			var (
+				repositories, pRepositories *config.RepoConfiguration
+				err                         error
+			)
+			logger := newLogger()
 			l := zapr.NewLogger(logger)
 			defer func() {
 				_ = logger.Sync() // flushes buffer, if any
 			}()
-			scmClient, err := createClientFromViper()
-			if err != nil {
-				return fmt.Errorf("failed to create a git driver: %s", err)
-			}
-			c := viper.GetString("config-path")
-			if c != "" {
-				repositories, err = config.Load(c)
+			ctx, cancel := commandContext(cmd)
+			defer cancel()
+			if configExists() {
+				repositories, err = loadConfig(ctx, false)
 				if err != nil {
-					l.Info("Error trying to read repositories yaml config from file", "content", repositories, "error", err)
+					return fmt.Errorf("failed to load the repositories config: %w", err)
 				}
 			}
 
 			if repositories == nil {
 				l.Info("No repositories from config. Using flags or env")
-				applier := applier.New(l, client.New(scmClient), nil)
-				return applier.UpdateRepository(context.Background(), configFromFlags(), viper.GetString("new-value"))
+				a, err := newApplier(l, nil)
+				if err != nil {
+					return err
+				}
+				return a.UpdateRepository(ctx, configFromFlags(), viper.GetString("new-value"))
 			}
 
+			sources := repositories.Clone()
 			pRepositories, err = processConfigsAndOverrides(repositories)
 			if err != nil {
 				return fmt.Errorf("failing to update to to error: %s", err)
-			} else if pRepositories == nil {
-				return fmt.Errorf("failing to update as processing repositories config returned an empty list")
+			} else if pRepositories == nil || len(pRepositories.Repositories) == 0 {
+				return &exitError{code: exitNothingToDo, err: fmt.Errorf("no enabled repositories to update in the repositories config")}
+			}
+			opts := []applier.Option{applier.ValueSources(sources)}
+			if viper.GetBool("fail-fast") {
+				opts = append(opts, applier.FailFast())
+			}
+			a, err := newApplier(l, pRepositories, opts...)
+			if err != nil {
+				return err
+			}
+			err = a.UpdateRepositories(ctx, viper.GetString("new-value"))
+			for _, r := range a.Results() {
+				kv := []interface{}{"repositoryKey", r.Key, "status", r.Status}
+				if r.Err != nil {
+					kv = append(kv, "reason", r.Err.Error())
+				}
+				l.Info("Repository update result", kv...)
 			}
-			applier := applier.New(l, client.New(scmClient), pRepositories)
-			return applier.UpdateRepositories(context.Background(), viper.GetString("new-value"))
+			return err
 		},
 	}
 
@@ -63,12 +79,12 @@ func makeUpdateCmd() *cobra.Command {
 	)
 	logIfError(viper.BindPFlag("new-value", cmd.Flags().Lookup("new-value")))
 
-	cmd.Flags().String(
-		"config-path",
-		".yaml-updater.yaml",
-		"List of repository configurations to apply update to. If file exists, it takes precedence over repository details passed via flags",
+	cmd.Flags().Bool(
+		"fail-fast",
+		false,
+		"Stop at the first repository that fails to update, instead of continuing with the rest and reporting all the failures",
 	)
-	logIfError(viper.BindPFlag("config-path", cmd.Flags().Lookup("config-path")))
+	logIfError(viper.BindPFlag("fail-fast", cmd.Flags().Lookup("fail-fast")))
 
 	addConfigFlags(cmd)
 
@@ -94,16 +110,6 @@ func addConfigFlags(cmd *cobra.Command) {
 	)
 	logIfError(viper.BindPFlag("disabled", cmd.Flags().Lookup("disabled")))
 
-	cmd.Flags().String(
-		"only",
-		"",
-		"A single or comman separated list of keys of the repositories defined in configuration to update and use. By default all are enabled "+
-			"unless they are explicitely disabled. If given, any repository not in the only list will be disabled and if in the list, enabled. "+
-			"This is why it takes precedence over the repositories 'disabled' field. "+
-			"NOTE: This is different than the override keys in that it will disable any repository not in the list",
-	)
-	logIfError(viper.BindPFlag("only", cmd.Flags().Lookup("only")))
-
 	cmd.Flags().String(
 		"override-repositories",
 		"",
@@ -160,6 +166,13 @@ func addConfigFlags(cmd *cobra.Command) {
 	)
 	logIfError(viper.BindPFlag("update-key", cmd.Flags().Lookup("update-key")))
 
+	cmd.Flags().String(
+		"value-from",
+		"",
+		"Key of a repository in configuration to read the new value from, at its update-key in its file-path and source-branch, instead of using --new-value",
+	)
+	logIfError(viper.BindPFlag("value-from", cmd.Flags().Lookup("value-from")))
+
 	cmd.Flags().String(
 		"branch-generate-name",
 		"gitops-",
@@ -168,6 +181,23 @@ func addConfigFlags(cmd *cobra.Command) {
 	)
 	logIfError(viper.BindPFlag("branch-generate-name", cmd.Flags().Lookup("branch-generate-name")))
 
+	cmd.Flags().String(
+		"branch-naming",
+		"",
+		"How the name of the branch for the PR follows --branch-generate-name: random, the default, hash of the repository key and the value, "+
+			"which reuses the branch of a previous run for the same value instead of creating another PR, timestamp, or template. "+
+			"When set, either via flag or env, it overrides all repository configs from yaml",
+	)
+	logIfError(viper.BindPFlag("branch-naming", cmd.Flags().Lookup("branch-naming")))
+
+	cmd.Flags().String(
+		"branch-template",
+		"",
+		"Go template for the name of the branch for the PR, e.g. 'gitops/{{.Key}}/{{.Value | slug}}', rendered with "+
+			".Prefix, .Key, .Name, .Repo and .Value. When set, either via flag or env, it overrides all repository configs from yaml",
+	)
+	logIfError(viper.BindPFlag("branch-template", cmd.Flags().Lookup("branch-template")))
+
 	cmd.Flags().Bool(
 		"create-missing",
 		true,
@@ -176,6 +206,21 @@ func addConfigFlags(cmd *cobra.Command) {
 	)
 	logIfError(viper.BindPFlag("create-missing", cmd.Flags().Lookup("create-missing")))
 
+	cmd.Flags().String(
+		"template",
+		"",
+		"Template used as the initial content of the file when create-missing applies and the file does not exist. "+
+			"It is rendered with .Key, .Name and .Value before the update-key is set. Takes precedence over template-file",
+	)
+	logIfError(viper.BindPFlag("template", cmd.Flags().Lookup("template")))
+
+	cmd.Flags().String(
+		"template-file",
+		"",
+		"Path to a local template file used as with --template. Prefix it with 'repo:' to read it from the source-repo at the source-branch instead",
+	)
+	logIfError(viper.BindPFlag("template-file", cmd.Flags().Lookup("template-file")))
+
 	cmd.Flags().Bool(
 		"remove-key",
 		false,
@@ -192,6 +237,22 @@ func addConfigFlags(cmd *cobra.Command) {
 	)
 	logIfError(viper.BindPFlag("remove-file", cmd.Flags().Lookup("remove-file")))
 
+	cmd.Flags().String(
+		"copy-from",
+		"",
+		"Path within the source-repo to copy to file-path before applying the update-key change, if any. "+
+			"When set, either via flag or env, it overrides all repository configs from yaml",
+	)
+	logIfError(viper.BindPFlag("copy-from", cmd.Flags().Lookup("copy-from")))
+
+	cmd.Flags().String(
+		"move-to",
+		"",
+		"Path within the source-repo to move file-path to, applying the update-key change, if any, to the moved file. "+
+			"When set, either via flag or env, it overrides all repository configs from yaml",
+	)
+	logIfError(viper.BindPFlag("move-to", cmd.Flags().Lookup("move-to")))
+
 	cmd.Flags().String(
 		"committer-name",
 		"",
@@ -224,10 +285,17 @@ func configFromFlags() *config.Repository {
 		SourceBranch:       viper.GetString("source-branch"),
 		FilePath:           viper.GetString("file-path"),
 		UpdateKey:          viper.GetString("update-key"),
+		ValueFrom:          viper.GetString("value-from"),
 		BranchGenerateName: viper.GetString("branch-generate-name"),
+		BranchNaming:       viper.GetString("branch-naming"),
+		BranchTemplate:     viper.GetString("branch-template"),
 		RemoveKey:          viper.GetBool("remove-key"),
 		RemoveFile:         viper.GetBool("remove-file"),
+		CopyFrom:           viper.GetString("copy-from"),
+		MoveTo:             viper.GetString("move-to"),
 		CreateMissing:      viper.GetBool("create-missing"),
+		Template:           viper.GetString("template"),
+		TemplateFile:       viper.GetString("template-file"),
 		CommitMsg:          viper.GetString("commit-msg"),
 		DisablePRCreation:  viper.GetBool("disable-pr-creation"),
 		Signature: &config.Signature{
@@ -237,10 +305,28 @@ func configFromFlags() *config.Repository {
 	}
 }
 
+// applyOnly enables every repository in only, and removes from configs any
+// repository not in only, regardless of its "disabled" value.
+func applyOnly(configs *config.RepoConfiguration, only []string) {
+	for _, repo := range configs.Keys() {
+		var inOnly bool
+		for _, r := range only {
+			if repo == r {
+				inOnly = true
+			}
+		}
+		if inOnly {
+			configs.Repositories[repo].Disabled = false
+		} else {
+			delete(configs.Repositories, repo)
+		}
+	}
+}
+
 // processConfigsAndOverrides is used to set cli or env overrides over configuration
 // from files
 func processConfigsAndOverrides(configs *config.RepoConfiguration) (*config.RepoConfiguration, error) {
-	var reposToOverride, overrideRepos, only []string
+	var reposToOverride, only []string
 
 	repoKeys := configs.Keys()
 
@@ -249,31 +335,21 @@ func processConfigsAndOverrides(configs *config.RepoConfiguration) (*config.Repo
 	}
 
 	if overrideReposVal := viper.GetString("override-repositories"); overrideReposVal != "" {
-		overrideRepos = strings.Split(overrideReposVal, ",")
+		overrideRepos, err := expandKeys(configs, strings.Split(overrideReposVal, ","))
+		if err != nil {
+			return nil, err
+		}
 		reposToOverride = overrideRepos
 	}
 
-	if onlyVal := viper.GetString("only"); onlyVal != "" {
-		only = strings.Split(onlyVal, ",")
+	selected, ok, err := selectedKeys(configs)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		only = selected
 		reposToOverride = only
-		// Process all repositories when only is given
-		// to remove any repo not in only
-		for _, repo := range repoKeys {
-			// If --only is set and repo not in only, it will be deleted
-			// regardless of "disabled" value. Likewise, if disabled,
-			// it will be enabled and used.
-			var inOnly bool
-			for _, r := range only {
-				if repo == r {
-					inOnly = true
-				}
-			}
-			if len(only) > 0 && inOnly {
-				configs.Repositories[repo].Disabled = false
-			} else if len(only) > 0 {
-				delete(configs.Repositories, repo)
-			}
-		}
+		applyOnly(configs, only)
 	}
 
 	for _, repo := range reposToOverride {
@@ -310,18 +386,39 @@ func processConfigsAndOverrides(configs *config.RepoConfiguration) (*config.Repo
 		if viper.IsSet("update-key") {
 			configs.Repositories[repo].UpdateKey = viper.GetString("update-key")
 		}
+		if viper.IsSet("value-from") {
+			configs.Repositories[repo].ValueFrom = viper.GetString("value-from")
+		}
 		if viper.IsSet("branch-generate-name") {
 			configs.Repositories[repo].BranchGenerateName = viper.GetString("branch-generate-name")
 		}
+		if viper.IsSet("branch-naming") {
+			configs.Repositories[repo].BranchNaming = viper.GetString("branch-naming")
+		}
+		if viper.IsSet("branch-template") {
+			configs.Repositories[repo].BranchTemplate = viper.GetString("branch-template")
+		}
 		if viper.IsSet("remove-key") {
 			configs.Repositories[repo].RemoveKey = viper.GetBool("remove-key")
 		}
 		if viper.IsSet("remove-file") {
 			configs.Repositories[repo].RemoveFile = viper.GetBool("remove-file")
 		}
+		if viper.IsSet("copy-from") {
+			configs.Repositories[repo].CopyFrom = viper.GetString("copy-from")
+		}
+		if viper.IsSet("move-to") {
+			configs.Repositories[repo].MoveTo = viper.GetString("move-to")
+		}
 		if viper.IsSet("create-missing") {
 			configs.Repositories[repo].CreateMissing = viper.GetBool("create-missing")
 		}
+		if viper.IsSet("template") {
+			configs.Repositories[repo].Template = viper.GetString("template")
+		}
+		if viper.IsSet("template-file") {
+			configs.Repositories[repo].TemplateFile = viper.GetString("template-file")
+		}
 		if viper.IsSet("commit-msg") {
 			configs.Repositories[repo].CommitMsg = viper.GetString("commit-msg")
 		}
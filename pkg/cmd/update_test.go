@@ -7,6 +7,7 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/ocraviotto/yaml-updater/pkg/config"
+	"github.com/ocraviotto/yaml-updater/test"
 	"github.com/spf13/viper"
 )
 
@@ -173,6 +174,46 @@ func TestOverrides(t *testing.T) {
 				},
 			},
 		},
+		{
+			"testSelector",
+			"testdata/labeled-repositories.yaml",
+			&testFlags{
+				"selector":      "env=prod,team in (pay*)",
+				"source-branch": "release",
+			},
+			&config.RepoConfiguration{
+				Repositories: map[string]*config.Repository{
+					"payments-prod": labeledRepo("payments", "prod", "release"),
+				},
+			},
+		},
+		{
+			"testOnlyPatternWithSelector",
+			"testdata/labeled-repositories.yaml",
+			&testFlags{
+				"only":     "*-prod",
+				"selector": "team!=payments",
+			},
+			&config.RepoConfiguration{
+				Repositories: map[string]*config.Repository{
+					"search-prod": labeledRepo("search", "prod", "main"),
+				},
+			},
+		},
+		{
+			"testOverrideRepositoriesPattern",
+			"testdata/labeled-repositories.yaml",
+			&testFlags{
+				"override-repositories": "*-prod",
+				"source-branch":         "release",
+			},
+			&config.RepoConfiguration{
+				Repositories: map[string]*config.Repository{
+					"payments-dev": labeledRepo("payments", "dev", "main"),
+					"search-prod":  labeledRepo("search", "prod", "release"),
+				},
+			},
+		},
 	}
 
 	for _, tt := range parseTests {
@@ -194,6 +235,75 @@ func TestOverrides(t *testing.T) {
 	}
 }
 
+func TestSelectedKeysErrors(t *testing.T) {
+	selectTests := []struct {
+		flags   *testFlags
+		wantErr string
+	}{
+		{&testFlags{"only": "staging-*"}, "user given pattern: staging-\\* does not match any repository"},
+		{&testFlags{"selector": "env=qa"}, "no repository in the current repositories config matches the selection"},
+		{&testFlags{"selector": "env in dev"}, `invalid selector "env in dev"`},
+	}
+
+	for _, tt := range selectTests {
+		t.Run(fmt.Sprintf("%v", *tt.flags), func(rt *testing.T) {
+			initViper()
+			makeUpdateCmd()
+			repositories := loadRepositoriesFromFile("testdata/labeled-repositories.yaml", rt)
+			setViperFromTestFlags(tt.flags)
+
+			_, err := processConfigsAndOverrides(repositories)
+			if !test.MatchError(rt, tt.wantErr, err) {
+				rt.Fatalf("got error %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUpdateConfigErrors(t *testing.T) {
+	configTests := []struct {
+		configPath string
+		wantErr    string
+	}{
+		{"testdata/base-repositories.yaml,testdata/base-repositories.yaml", "failed to load the repositories config: repository testRepo1 is defined in both"},
+		{"testdata/include-cycle.yaml", "failed to load the repositories config: configuration testdata/include-cycle.yaml includes itself"},
+		{"testdata/base-repositories.yaml,testdata/missing.yaml", "failed to load the repositories config: .*missing.yaml: no such file or directory"},
+	}
+
+	for _, tt := range configTests {
+		t.Run(tt.configPath, func(rt *testing.T) {
+			initViper()
+			cmd := makeUpdateCmd()
+			viper.Set(configPathFlag, tt.configPath)
+
+			err := cmd.RunE(cmd, nil)
+			if !test.MatchError(rt, tt.wantErr, err) {
+				rt.Fatalf("got error %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfigExists(t *testing.T) {
+	existsTests := []struct {
+		configPath string
+		want       bool
+	}{
+		{"testdata/missing.yaml", false},
+		{"testdata/missing.yaml,testdata/base-repositories.yaml", true},
+		{"testdata", true},
+		{"repo://my-org/config@main:repositories.yaml", true},
+	}
+
+	for _, tt := range existsTests {
+		initViper()
+		viper.Set(configPathFlag, tt.configPath)
+		if got := configExists(); got != tt.want {
+			t.Errorf("configExists() with %s got %v, want %v", tt.configPath, got, tt.want)
+		}
+	}
+}
+
 func TestConfigFromFlags(t *testing.T) {
 	s := &config.Signature{
 		Name:  "John Doe",
@@ -268,6 +378,17 @@ func TestConfigFromFlags(t *testing.T) {
 	}
 }
 
+func labeledRepo(team, env, branch string) *config.Repository {
+	return &config.Repository{
+		Name:         "testing/repo-image",
+		Labels:       map[string]string{"env": env, "team": team},
+		SourceRepo:   "my-org/" + team,
+		SourceBranch: branch,
+		FilePath:     env + "/values.yaml",
+		UpdateKey:    "image.tag",
+	}
+}
+
 func loadRepositoriesFromFile(fileName string, rt *testing.T) (repositories *config.RepoConfiguration) {
 	repositories, err := config.Load(fileName)
 	if err != nil {
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/ocraviotto/pkg/client"
+	"github.com/ocraviotto/yaml-updater/pkg/config"
+)
+
+func makeValidateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "validate the repositories configuration",
+		Long: "Checks the repositories configuration for unknown fields, missing required fields and conflicting options. " +
+			"With --online, it also checks that the configured repositories, branches and files exist",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := commandContext(cmd)
+			defer cancel()
+			repositories, err := loadConfig(ctx, true)
+			if err != nil {
+				return fmt.Errorf("failed to read repositories yaml config from file: %w", err)
+			}
+			problems := validateRepositories(repositories)
+			if viper.GetBool("online") && len(problems) == 0 {
+				c, err := createGitClientFromViper()
+				if err != nil {
+					return err
+				}
+				connections, err := connectionClients(repositories)
+				if err != nil {
+					return err
+				}
+				problems = checkRepositoriesOnline(ctx, c, connections, repositories)
+			}
+			return reportProblems(cmd.OutOrStdout(), problems)
+		},
+	}
+
+	cmd.Flags().Bool(
+		"online",
+		false,
+		"Also check, using the Git service, that the repositories and branches exist, and that the files exist unless createMissing is set",
+	)
+	logIfError(viper.BindPFlag("online", cmd.Flags().Lookup("online")))
+
+	return cmd
+}
+
+// validateRepositories returns the problems found in configs, including
+// any key given with --only that does not exist, or a selection that matches
+// no repository.
+func validateRepositories(configs *config.RepoConfiguration) []string {
+	var problems []string
+	if err := configs.Validate(); err != nil {
+		var verr *config.ValidationError
+		if !errors.As(err, &verr) {
+			return []string{err.Error()}
+		}
+		problems = append(problems, verr.Problems...)
+	}
+	only, _, err := selectedKeys(configs)
+	if err != nil {
+		return append(problems, err.Error())
+	}
+	for _, repo := range only {
+		if configs.Find(repo) == nil {
+			problems = append(problems, fmt.Sprintf("--only: repository %s does not exist", repo))
+		}
+	}
+	return problems
+}
+
+// checkRepositoriesOnline checks that the branch and files that each enabled
+// repository in configs needs exist, using c or the client in connections for
+// the connection of the repository.
+func checkRepositoriesOnline(ctx context.Context, c client.GitClient, connections map[string]client.GitClient, configs *config.RepoConfiguration) []string {
+	var problems []string
+	keys := configs.Keys()
+	for _, key := range keys {
+		repo := configs.Repositories[key]
+		if repo.Disabled {
+			continue
+		}
+		c := c
+		if repo.Connection != "" {
+			c = connections[repo.Connection]
+		}
+		if _, err := c.GetBranchHead(ctx, repo.SourceRepo, repo.SourceBranch); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: failed to get branch %s in repo %s: %s", key, repo.SourceBranch, repo.SourceRepo, err))
+			continue
+		}
+		files := []string{repo.CopyFrom}
+		if !repo.CreateMissing || repo.RemoveFile || repo.MoveTo != "" {
+			files = append(files, repo.FilePath)
+		}
+		for _, path := range files {
+			if path == "" {
+				continue
+			}
+			if _, err := c.GetFile(ctx, repo.SourceRepo, repo.SourceBranch, path); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: failed to get file %s in repo %s branch %s: %s", key, path, repo.SourceRepo, repo.SourceBranch, err))
+			}
+		}
+	}
+	return problems
+}
+
+func reportProblems(w io.Writer, problems []string) error {
+	if len(problems) == 0 {
+		fmt.Fprintln(w, "repositories config is valid")
+		return nil
+	}
+	for _, p := range problems {
+		fmt.Fprintln(w, p)
+	}
+	return fmt.Errorf("found %d problems in the repositories config", len(problems))
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ocraviotto/pkg/client"
+	"github.com/ocraviotto/pkg/client/mock"
+	"github.com/ocraviotto/yaml-updater/pkg/config"
+)
+
+func TestCheckRepositoriesOnline(t *testing.T) {
+	m := mock.New(t)
+	m.AddBranchHead("my-org/my-project", "main", "980a0d5f19a64b4b30a87d4206aade58726b60e3")
+	m.AddFileContents("my-org/my-project", "service-a/deployment.yaml", "main", []byte("test: {}\n"))
+	internal := mock.New(t)
+	internal.AddBranchHead("platform/infra", "main", "980a0d5f19a64b4b30a87d4206aade58726b60e3")
+	internal.AddFileContents("platform/infra", "service-a/deployment.yaml", "main", []byte("test: {}\n"))
+	configs := &config.RepoConfiguration{
+		Repositories: map[string]*config.Repository{
+			"existing": {SourceRepo: "my-org/my-project", SourceBranch: "main", FilePath: "service-a/deployment.yaml"},
+			"created":  {SourceRepo: "my-org/my-project", SourceBranch: "main", FilePath: "service-b/pod.yaml", CreateMissing: true},
+			"missing":  {SourceRepo: "my-org/my-project", SourceBranch: "main", FilePath: "service-b/pod.yaml"},
+			"branch":   {SourceRepo: "my-org/my-project", SourceBranch: "dev", FilePath: "service-a/deployment.yaml"},
+			"internal": {SourceRepo: "platform/infra", SourceBranch: "main", FilePath: "service-a/deployment.yaml", Connection: "internal"},
+			"disabled": {SourceRepo: "my-org/my-project", SourceBranch: "dev", FilePath: "service-a/deployment.yaml", Disabled: true},
+		},
+	}
+
+	got := checkRepositoriesOnline(context.Background(), m, map[string]client.GitClient{"internal": internal}, configs)
+
+	want := []string{
+		"branch: failed to get branch dev in repo my-org/my-project: not found",
+		"missing: failed to get file service-b/pod.yaml in repo my-org/my-project branch main: not found",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("checkRepositoriesOnline() failed diff\n%s", diff)
+	}
+}
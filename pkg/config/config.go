@@ -1,53 +1,138 @@
 package config
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"os"
+	"sort"
 
 	"sigs.k8s.io/yaml"
 )
 
 // Repository is the items that are required to update a specific file in a repo.
 type Repository struct {
-	Name               string     `json:"name"`
-	Disabled           bool       `json:"disabled,omitempty"`
-	SourceRepo         string     `json:"sourceRepo"`
-	SourceBranch       string     `json:"sourceBranch"`
-	FilePath           string     `json:"filePath"`
-	UpdateKey          string     `json:"updateKey"`
-	BranchGenerateName string     `json:"branchGenerateName"`
-	DisablePRCreation  bool       `json:"disablePRCreation,omitempty"`
-	RemoveKey          bool       `json:"removeKey,omitempty"`
-	RemoveFile         bool       `json:"removeFile,omitempty"`
-	CreateMissing      bool       `json:"createMissing,omitempty"`
-	CommitMsg          string     `json:"commitMsg,omitempty"`
-	Signature          *Signature `json:"signature,omitempty"`
+	Name               string            `json:"name" description:"Name of the source of the change, used in the default commit message and PR title"`
+	Disabled           bool              `json:"disabled,omitempty" description:"Skip this repository unless it is selected with --only or --selector"`
+	Labels             map[string]string `json:"labels,omitempty" description:"Labels to select this repository with --selector, e.g. env: prod"`
+	Connection         string            `json:"connection,omitempty" description:"Key in connections of the Git service hosting sourceRepo, instead of the one given with the flags"`
+	SourceRepo         string            `json:"sourceRepo" description:"Git repository to update, e.g. org/repo"`
+	SourceBranch       string            `json:"sourceBranch" description:"Branch to fetch for updating, and to create the PR against"`
+	FilePath           string            `json:"filePath" description:"Path within sourceRepo to update"`
+	UpdateKey          string            `json:"updateKey" description:"JSON path within filePath to update, e.g. spec.template.spec.containers.0.image. Required unless removeFile, copyFrom or moveTo are set"`
+	Value              string            `json:"value,omitempty" description:"New value to set at updateKey, instead of the one given with --new-value"`
+	DependsOn          []string          `json:"dependsOn,omitempty" description:"Keys of repositories that must be updated successfully before this one, which is skipped otherwise"`
+	ValueFrom          string            `json:"valueFrom,omitempty" description:"Key of another repository to read the new value from, at its updateKey. Takes precedence over value"`
+	BranchGenerateName string            `json:"branchGenerateName" description:"Prefix for the name of the branch created for the PR"`
+	BranchNaming       string            `json:"branchNaming,omitempty" jsonschema:"enum=random|hash|timestamp|template" description:"How the name of the branch created for the PR follows branchGenerateName: random, the default, a hash of the key and the value, which reuses the branch of a previous run, a timestamp, or template"`
+	BranchTemplate     string            `json:"branchTemplate,omitempty" description:"Go template for the name of the branch created for the PR, rendered with .Prefix, .Key, .Name, .Repo and .Value and the slug function. Implies branchNaming template"`
+	DisablePRCreation  bool              `json:"disablePRCreation,omitempty" description:"Commit directly to sourceBranch instead of creating a PR"`
+	RemoveKey          bool              `json:"removeKey,omitempty" description:"Remove updateKey instead of updating it"`
+	RemoveFile         bool              `json:"removeFile,omitempty" description:"Remove filePath instead of updating it"`
+	CopyFrom           string            `json:"copyFrom,omitempty" description:"Path within sourceRepo to copy to filePath before updating it"`
+	MoveTo             string            `json:"moveTo,omitempty" description:"Path within sourceRepo to move filePath to before updating it"`
+	CreateMissing      bool              `json:"createMissing,omitempty" description:"Create filePath if it does not exist"`
+	Template           string            `json:"template,omitempty" description:"Go template for the initial content of a missing filePath, rendered with .Key, .Name and .Value"`
+	TemplateFile       string            `json:"templateFile,omitempty" description:"Local path, or repo: prefixed path within sourceRepo, of a template as in template"`
+	CommitMsg          string            `json:"commitMsg,omitempty" description:"Commit message, defaults to 'Automatic update from' followed by name"`
+	Signature          *Signature        `json:"signature,omitempty" description:"Name and email of the commit author"`
 }
 
 // Signature represents a git commit creator by name and email
 type Signature struct {
-	Name  string `json:"name,omitempty"`
-	Email string `json:"email,omitempty"`
+	Name  string `json:"name,omitempty" description:"Name of the commit author"`
+	Email string `json:"email,omitempty" description:"Email of the commit author"`
 }
 
-func Load(path string) (*RepoConfiguration, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-	return Parse(f)
+// Connection is how to connect to a Git service.
+type Connection struct {
+	Driver   string `json:"driver,omitempty" jsonschema:"enum=github|gitlab|gitea|gogs|bitbucket|bitbucketcloud|bitbucketserver|stash" description:"Git service driver, defaults to github"`
+	Endpoint string `json:"endpoint,omitempty" description:"API endpoint of the Git service, defaults to the public one of the driver"`
+	Username string `json:"username,omitempty" description:"Username, needed by bitbucketcloud"`
+	TokenEnv string `json:"tokenEnv,omitempty" description:"Name of the environment variable holding the auth token"`
+	// Other sources of the token, instead of tokenEnv.
+	TokenFile        string `json:"tokenFile,omitempty" description:"Path to a file holding the auth token, read again each time it is needed"`
+	TokenCommand     string `json:"tokenCommand,omitempty" description:"Shell command printing the auth token"`
+	CredentialHelper string `json:"credentialHelper,omitempty" description:"Git credential helper giving the auth token as password, as in the credential.helper setting of git"`
+	Insecure         bool   `json:"insecure,omitempty" description:"Skip the verification of the TLS certificate of the Git service"`
+	CAFile           string `json:"caFile,omitempty" description:"Path to a PEM encoded bundle of CA certificates to verify the Git service with, in addition to the system ones"`
+	ClientCert       string `json:"clientCert,omitempty" description:"Path to a PEM encoded client certificate to authenticate to the Git service with"`
+	ClientKey        string `json:"clientKey,omitempty" description:"Path to the PEM encoded key of clientCert"`
+	Proxy            string `json:"proxy,omitempty" description:"URL of the proxy for the requests to the Git service, instead of the one in HTTPS_PROXY"`
+	// The git backend, instead of the API.
+	Backend        string `json:"backend,omitempty" jsonschema:"enum=api|git" description:"How files are read and committed: api, the default, or git, with shallow clones"`
+	GitURL         string `json:"gitURL,omitempty" description:"URL to clone the repositories from with the git backend, where {repo} is replaced with the repository name"`
+	SSHKeyFile     string `json:"sshKeyFile,omitempty" description:"Path to the private key to authenticate to SSH URLs with"`
+	KnownHostsFile string `json:"knownHostsFile,omitempty" description:"Path to the known_hosts file to verify SSH servers with"`
+	// Signing of the commits made with the git backend.
+	SigningFormat  string `json:"signingFormat,omitempty" jsonschema:"enum=gpg|ssh" description:"Format of the key to sign the commits with: gpg, the default, or ssh"`
+	SigningKeyFile string `json:"signingKeyFile,omitempty" description:"Path to the private key to sign the commits with, without a passphrase"`
+	SigningKeyEnv  string `json:"signingKeyEnv,omitempty" description:"Name of the environment variable holding the private key to sign the commits with, instead of signingKeyFile"`
+	// GitHub App authentication, instead of a token.
+	AppID          int64  `json:"appID,omitempty" description:"ID of a GitHub App to authenticate as, with the github driver"`
+	InstallationID int64  `json:"installationID,omitempty" description:"ID of the installation of the GitHub App"`
+	PrivateKeyFile string `json:"privateKeyFile,omitempty" description:"Path to the PEM encoded private key of the GitHub App"`
+}
+
+// Load reads and returns the configuration in the files at the given paths,
+// see Loader.Load.
+func Load(paths ...string) (*RepoConfiguration, error) {
+	return Loader{}.Load(paths...)
+}
+
+// LoadStrict is like Load, but fails on unknown fields.
+func LoadStrict(paths ...string) (*RepoConfiguration, error) {
+	return Loader{Strict: true}.Load(paths...)
 }
 
 // Parse reads and returns a configuration from Reader.
+//
+// The ${VAR} and ${file:/path} references in its string values are expanded
+// with the value of the environment variable and the contents of the file.
 func Parse(in io.Reader) (*RepoConfiguration, error) {
+	return parse(in, false, nil)
+}
+
+// ParseStrict reads and returns a configuration from Reader, failing on
+// unknown or duplicated fields, and on references to undefined environment
+// variables.
+func ParseStrict(in io.Reader) (*RepoConfiguration, error) {
+	return parse(in, true, nil)
+}
+
+// parse reads a configuration, expanding only the references that allow
+// accepts, if not nil.
+func parse(in io.Reader, strict bool, allow allowFunc) (*RepoConfiguration, error) {
 	body, err := ioutil.ReadAll(in)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read YAML: %w", err)
 	}
+	unmarshal := yaml.Unmarshal
+	if strict {
+		unmarshal = yaml.UnmarshalStrict
+	}
 	rc := &RepoConfiguration{}
+	err = unmarshal(body, rc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal YAML: %w", err)
+	}
+	hasReferences := bytes.Contains(body, []byte("${"))
+	if rc.Defaults == nil && !hasReferences {
+		return rc, nil
+	}
+	if rc.Defaults != nil {
+		body, err = mergeDefaults(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to merge defaults: %w", err)
+		}
+	}
+	if hasReferences {
+		body, err = interpolate(body, strict, allow)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand references: %w", err)
+		}
+	}
+	rc = &RepoConfiguration{}
 	err = yaml.Unmarshal(body, rc)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal YAML: %w", err)
@@ -57,7 +142,10 @@ func Parse(in io.Reader) (*RepoConfiguration, error) {
 
 // RepoConfiguration is a slice of Repository values.
 type RepoConfiguration struct {
-	Repositories map[string]*Repository `json:"repositories"`
+	Include      []string               `json:"include,omitempty" description:"Other configuration files to merge in, relative to this one. Glob patterns are allowed"`
+	Defaults     *Repository            `json:"defaults,omitempty" jsonschema:"partial" description:"Values merged into every repository, unless the repository sets them"`
+	Connections  map[string]*Connection `json:"connections,omitempty" description:"Git services that repositories can refer to with connection, by key"`
+	Repositories map[string]*Repository `json:"repositories" jsonschema:"required" description:"Repositories to update, by key"`
 }
 
 // Find looks up the repository by key in a list or RepoConfiguration.Repositories.
@@ -70,15 +158,40 @@ func (c RepoConfiguration) Find(repoKey string) *Repository {
 	return nil
 }
 
-// Keys returns the Repository keys in RepoConfiguration.Repositories.
+// Keys returns the sorted Repository keys in RepoConfiguration.Repositories.
 func (c RepoConfiguration) Keys() []string {
 	var keys []string
 	for key := range c.Repositories {
 		keys = append(keys, key)
 	}
+	sort.Strings(keys)
 	return keys
 }
 
+// Clone returns a copy of the RepoConfiguration that does not share any
+// Repository with it. Connections are shared.
+func (c RepoConfiguration) Clone() *RepoConfiguration {
+	clone := &RepoConfiguration{Connections: c.Connections, Repositories: make(map[string]*Repository, len(c.Repositories))}
+	for key, cfg := range c.Repositories {
+		repo := *cfg
+		if cfg.Signature != nil {
+			s := *cfg.Signature
+			repo.Signature = &s
+		}
+		if cfg.DependsOn != nil {
+			repo.DependsOn = append([]string(nil), cfg.DependsOn...)
+		}
+		if cfg.Labels != nil {
+			repo.Labels = make(map[string]string, len(cfg.Labels))
+			for k, v := range cfg.Labels {
+				repo.Labels[k] = v
+			}
+		}
+		clone.Repositories[key] = &repo
+	}
+	return clone
+}
+
 // ApplyOverrides will decide if and how to apply cli values when
 // there is a matching config with existing repositories
 func (c RepoConfiguration) ApplyOverrides() RepoConfiguration {
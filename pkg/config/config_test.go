@@ -1,11 +1,18 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/ocraviotto/yaml-updater/test"
+	"sigs.k8s.io/yaml"
 )
 
 func TestRepoConfigurationFind(t *testing.T) {
@@ -121,6 +128,42 @@ func TestConfigLoad(t *testing.T) {
 				},
 			},
 		},
+		{
+			"testdata/defaults.yaml", &RepoConfiguration{
+				Defaults: &Repository{
+					SourceRepo:         "my-org/my-project",
+					SourceBranch:       "main",
+					BranchGenerateName: "repo-imager-",
+					CreateMissing:      true,
+					Signature:          s,
+				},
+				Repositories: map[string]*Repository{
+					"testRepo1": {
+						Name:               "testing/repo-image",
+						SourceRepo:         "my-org/my-project",
+						SourceBranch:       "main",
+						FilePath:           "service-a/deployment.yaml",
+						UpdateKey:          "spec.template.spec.containers.0.image",
+						BranchGenerateName: "repo-imager-",
+						CreateMissing:      true,
+						Signature:          s,
+					},
+					"testRepo2": {
+						Name:               "testing/repo-image",
+						SourceRepo:         "my-org/my-other-project",
+						SourceBranch:       "main",
+						FilePath:           "service-a/deployment.yaml",
+						UpdateKey:          "spec.template.spec.containers.0.image",
+						BranchGenerateName: "repo-imager-",
+						CreateMissing:      false,
+						Signature: &Signature{
+							Name:  "Jane Doe",
+							Email: "john.doe@example.com",
+						},
+					},
+				},
+			},
+		},
 	}
 
 	for _, tt := range parseTests {
@@ -136,3 +179,601 @@ func TestConfigLoad(t *testing.T) {
 		})
 	}
 }
+
+func TestParseStrict(t *testing.T) {
+	f, err := os.Open("testdata/config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	_, err = ParseStrict(f)
+	if !test.MatchError(t, `unknown field "tagMatch"`, err) {
+		t.Fatalf("got %v, want unknown field error", err)
+	}
+}
+
+func TestParseReferences(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "email")
+	if err := ioutil.WriteFile(secret, []byte("bot@example.com\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("YAML_UPDATER_BRANCH", "release-1.2")
+	body := `
+repositories:
+  testRepo:
+    sourceRepo: my-org/my-project
+    sourceBranch: ${YAML_UPDATER_BRANCH}
+    filePath: service-a/deployment.yaml
+    updateKey: spec.template.spec.containers.0.image
+    value: my-org/my-project:${YAML_UPDATER_TAG}
+    commitMsg: Update to $${YAML_UPDATER_TAG} in ${YAML_UPDATER_BRANCH}
+    signature:
+      email: ${file:` + secret + `}
+`
+	parseTests := []struct {
+		name    string
+		parse   func(io.Reader) (*RepoConfiguration, error)
+		want    *Repository
+		wantErr string
+	}{
+		{
+			"parse",
+			Parse,
+			&Repository{
+				SourceRepo:   "my-org/my-project",
+				SourceBranch: "release-1.2",
+				FilePath:     "service-a/deployment.yaml",
+				UpdateKey:    "spec.template.spec.containers.0.image",
+				Value:        "my-org/my-project:",
+				CommitMsg:    "Update to ${YAML_UPDATER_TAG} in release-1.2",
+				Signature:    &Signature{Email: "bot@example.com"},
+			},
+			"",
+		},
+		{
+			"strict",
+			ParseStrict,
+			nil,
+			"repositories.testRepo.value: environment variable YAML_UPDATER_TAG is not set",
+		},
+	}
+
+	for _, tt := range parseTests {
+		t.Run(tt.name, func(rt *testing.T) {
+			got, err := tt.parse(strings.NewReader(body))
+			if !test.MatchError(rt, tt.wantErr, err) {
+				rt.Fatalf("got error %v, want %s", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(tt.want, got.Find("testRepo")); diff != "" {
+				rt.Errorf("parse failed diff\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSelector(t *testing.T) {
+	labels := map[string]string{"env": "prod", "team": "payments"}
+	selectorTests := []struct {
+		selector string
+		want     bool
+		wantErr  string
+	}{
+		{"env=prod", true, ""},
+		{"env==prod,team=payments", true, ""},
+		{"env=prod,team=search", false, ""},
+		{"env!=dev", true, ""},
+		{"region!=eu", true, ""},
+		{"env in (dev, prod)", true, ""},
+		{"env notin (dev,prod),team=payments", false, ""},
+		{"team=pay*", true, ""},
+		{"env", true, ""},
+		{"!region", true, ""},
+		{"!env", false, ""},
+		{"", false, "empty requirement"},
+		{"env=prod,", false, "empty requirement"},
+		{"env in dev", false, `invalid label key in "env in dev"`},
+		{"team=[pay", false, "syntax error in pattern"},
+	}
+
+	for _, tt := range selectorTests {
+		t.Run(tt.selector, func(rt *testing.T) {
+			sel, err := ParseSelector(tt.selector)
+			if !test.MatchError(rt, tt.wantErr, err) {
+				rt.Fatalf("got error %v, want %s", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := sel.Matches(labels); got != tt.want {
+				rt.Fatalf("Matches(%v) got %v, want %v", labels, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() *Repository {
+		return &Repository{
+			SourceRepo:   "my-org/my-project",
+			SourceBranch: "main",
+			FilePath:     "service-a/deployment.yaml",
+			UpdateKey:    "spec.template.spec.containers.0.image",
+		}
+	}
+	validateTests := []struct {
+		name   string
+		modify func(r *Repository)
+		want   []string
+	}{
+		{"valid", func(r *Repository) {}, nil},
+		{
+			"missing required fields",
+			func(r *Repository) { *r = Repository{} },
+			[]string{
+				"testRepo: sourceRepo is required",
+				"testRepo: sourceBranch is required",
+				"testRepo: filePath is required",
+				"testRepo: updateKey is required unless removeFile, copyFrom or moveTo are set",
+			},
+		},
+		{
+			"remove key and file",
+			func(r *Repository) { r.RemoveKey, r.RemoveFile = true, true },
+			[]string{"testRepo: removeKey and removeFile can not be used together"},
+		},
+		{
+			"copy and move",
+			func(r *Repository) { r.CopyFrom, r.MoveTo = "a.yaml", "b.yaml" },
+			[]string{"testRepo: copyFrom and moveTo can not be used together"},
+		},
+		{
+			"template without createMissing",
+			func(r *Repository) { r.Template = "test: {}" },
+			[]string{"testRepo: template and templateFile require createMissing"},
+		},
+		{
+			"branch template missing",
+			func(r *Repository) { r.BranchNaming = "template" },
+			[]string{"testRepo: branchNaming template requires branchTemplate"},
+		},
+		{
+			"branch template with hash",
+			func(r *Repository) { r.BranchNaming, r.BranchTemplate = "hash", "gitops/{{.Key}}" },
+			[]string{"testRepo: branchTemplate has no effect with branchNaming hash"},
+		},
+		{
+			"value with removeKey",
+			func(r *Repository) { r.RemoveKey, r.Value = true, "v1" },
+			[]string{"testRepo: value has no effect with removeKey"},
+		},
+		{
+			"unknown valueFrom",
+			func(r *Repository) { r.ValueFrom = "unknown" },
+			[]string{"testRepo: valueFrom references unknown repository unknown"},
+		},
+		{
+			"self valueFrom",
+			func(r *Repository) { r.ValueFrom = "testRepo" },
+			[]string{"testRepo: valueFrom can not reference the repository itself"},
+		},
+		{
+			"unknown dependsOn",
+			func(r *Repository) { r.DependsOn = []string{"unknown"} },
+			[]string{"testRepo: dependsOn references unknown repository unknown"},
+		},
+		{
+			"unknown connection",
+			func(r *Repository) { r.Connection = "unknown" },
+			[]string{"testRepo: connection references unknown connection unknown"},
+		},
+		{
+			"unknown branchNaming",
+			func(r *Repository) { r.BranchNaming = "hsh" },
+			[]string{"testRepo: unknown branchNaming hsh, must be one of random, hash, timestamp, template"},
+		},
+		{
+			"self dependsOn",
+			func(r *Repository) { r.DependsOn = []string{"testRepo"} },
+			[]string{"dependsOn has a cycle among repositories testRepo"},
+		},
+	}
+
+	for _, tt := range validateTests {
+		t.Run(tt.name, func(rt *testing.T) {
+			repo := valid()
+			tt.modify(repo)
+			cfgs := RepoConfiguration{Repositories: map[string]*Repository{"testRepo": repo}}
+
+			var got []string
+			if err := cfgs.Validate(); err != nil {
+				got = err.(*ValidationError).Problems
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				rt.Errorf("Validate() failed diff\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestValidateConnections(t *testing.T) {
+	connectionTests := []struct {
+		name       string
+		connection *Connection
+		want       []string
+	}{
+		{"valid", &Connection{Driver: "gitlab", Backend: "git", SigningFormat: "ssh"}, nil},
+		{"defaults", &Connection{}, nil},
+		{"unknown driver", &Connection{Driver: "gitlabs"}, []string{"connection internal: unknown driver gitlabs, must be one of github, gitlab, gitea, gogs, bitbucket, bitbucketcloud, bitbucketserver, stash"}},
+		{"unknown backend", &Connection{Backend: "ssh"}, []string{"connection internal: unknown backend ssh, must be one of api, git"}},
+		{"unknown signingFormat", &Connection{SigningFormat: "x509"}, []string{"connection internal: unknown signingFormat x509, must be one of gpg, ssh"}},
+	}
+
+	for _, tt := range connectionTests {
+		t.Run(tt.name, func(rt *testing.T) {
+			cfgs := RepoConfiguration{
+				Repositories: map[string]*Repository{"testRepo": {SourceRepo: "my-org/my-project", SourceBranch: "main", FilePath: "values.yaml", UpdateKey: "image", Connection: "internal"}},
+				Connections:  map[string]*Connection{"internal": tt.connection},
+			}
+
+			var got []string
+			if err := cfgs.Validate(); err != nil {
+				got = err.(*ValidationError).Problems
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				rt.Errorf("Validate() failed diff\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestOrder(t *testing.T) {
+	repos := func(deps map[string][]string) RepoConfiguration {
+		c := RepoConfiguration{Repositories: map[string]*Repository{}}
+		for key, d := range deps {
+			c.Repositories[key] = &Repository{DependsOn: d}
+		}
+		return c
+	}
+	orderTests := []struct {
+		name    string
+		configs RepoConfiguration
+		want    []string
+		wantErr string
+	}{
+		{
+			"sorted by key",
+			repos(map[string][]string{"prod": nil, "dev": nil, "staging": nil}),
+			[]string{"dev", "prod", "staging"},
+			"",
+		},
+		{
+			"dependencies first",
+			repos(map[string][]string{"a-prod": {"staging"}, "staging": {"dev"}, "dev": nil, "b": nil}),
+			[]string{"b", "dev", "staging", "a-prod"},
+			"",
+		},
+		{
+			"unknown dependencies ignored",
+			repos(map[string][]string{"prod": {"staging"}, "dev": nil}),
+			[]string{"dev", "prod"},
+			"",
+		},
+		{
+			"cycle",
+			repos(map[string][]string{"a": {"b"}, "b": {"a"}, "c": nil}),
+			nil,
+			"dependsOn has a cycle among repositories a, b",
+		},
+	}
+
+	for _, tt := range orderTests {
+		t.Run(tt.name, func(rt *testing.T) {
+			got, err := tt.configs.Order()
+			if !test.MatchError(rt, tt.wantErr, err) {
+				rt.Fatalf("got error %v, want %s", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				rt.Errorf("Order() failed diff\n%s", diff)
+			}
+		})
+	}
+}
+
+// The published schema is generated with "yaml-updater schema", this makes
+// sure it is regenerated when the configuration changes.
+func TestJSONSchema(t *testing.T) {
+	published, err := ioutil.ReadFile("../../schema/repositories.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.MarshalIndent(JSONSchema(), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(string(published), string(b)+"\n"); diff != "" {
+		t.Errorf("published schema is out of date, regenerate it with 'yaml-updater schema':\n%s", diff)
+	}
+}
+
+func TestJSONSchemaWithDefaults(t *testing.T) {
+	body, err := ioutil.ReadFile("testdata/defaults.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw struct {
+		Repositories map[string]map[string]interface{} `json:"repositories"`
+	}
+	if err := yaml.Unmarshal(body, &raw); err != nil {
+		t.Fatal(err)
+	}
+
+	// The entries are checked as written, before merging the defaults, as
+	// editors and CI validate them.
+	required := JSONSchema().Definitions["Repository"].Required
+	for key, entry := range raw.Repositories {
+		for _, name := range required {
+			if _, ok := entry[name]; !ok {
+				t.Errorf("schema requires %s in repository %s, which takes it from defaults", name, key)
+			}
+		}
+	}
+}
+
+func TestLoaderLoad(t *testing.T) {
+	repo := func(name, branch string) *Repository {
+		return &Repository{
+			Name:         "testing/repo-image",
+			SourceRepo:   "my-org/" + name,
+			SourceBranch: branch,
+			FilePath:     name + "/values.yaml",
+			UpdateKey:    "image.tag",
+		}
+	}
+	fetch := func(repo, ref, path string) ([]byte, error) {
+		if repo != "my-org/config" || ref != "main" {
+			return nil, fmt.Errorf("not found")
+		}
+		return ioutil.ReadFile("testdata/" + path)
+	}
+	loadTests := []struct {
+		name    string
+		loader  Loader
+		paths   []string
+		want    map[string]*Repository
+		wantErr string
+	}{
+		{
+			"includes",
+			Loader{},
+			[]string{"testdata/compose/base.yaml"},
+			map[string]*Repository{
+				"platform": repo("platform", "main"),
+				"payments": repo("payments", "main"),
+				"search":   repo("search", "main"),
+			},
+			"",
+		},
+		{
+			"directory",
+			Loader{},
+			[]string{"testdata/compose/teams"},
+			map[string]*Repository{
+				"payments": repo("payments", "main"),
+				"search":   repo("search", "main"),
+			},
+			"",
+		},
+		{
+			"duplicated keys",
+			Loader{},
+			[]string{"testdata/compose/base.yaml", "testdata/compose/override.yaml"},
+			nil,
+			"repository payments is defined in both testdata/compose/teams/payments.yaml and testdata/compose/override.yaml",
+		},
+		{
+			"overrides",
+			Loader{AllowOverrides: true},
+			[]string{"testdata/compose/base.yaml", "testdata/compose/override.yaml"},
+			map[string]*Repository{
+				"platform": repo("platform", "main"),
+				"payments": repo("payments", "release"),
+				"search":   repo("search", "main"),
+			},
+			"",
+		},
+		{
+			"include cycle",
+			Loader{},
+			[]string{"testdata/compose-cycle/a.yaml"},
+			nil,
+			"configuration testdata/compose-cycle/a.yaml includes itself",
+		},
+		{
+			"remote",
+			Loader{Fetch: fetch},
+			[]string{"repo://my-org/config@main:compose/remote.yaml"},
+			map[string]*Repository{
+				"payments": repo("payments", "main"),
+				"search":   repo("search", "main"),
+			},
+			"",
+		},
+		{
+			"remote with glob include",
+			Loader{Fetch: fetch},
+			[]string{"repo://my-org/config@main:compose/base.yaml"},
+			nil,
+			"include teams/\\*.yaml in repo://my-org/config@main:compose/base.yaml: glob patterns are not supported in remote configurations",
+		},
+		{
+			"remote not fetched with a local copy",
+			Loader{Fetch: fetch},
+			[]string{"repo://my-org/config@release:testdata/compose/teams/search.yaml"},
+			nil,
+			"failed to fetch repo://my-org/config@release:testdata/compose/teams/search.yaml: not found",
+		},
+		{
+			"remote without fetch",
+			Loader{},
+			[]string{"repo://my-org/config@main:compose/remote.yaml"},
+			nil,
+			"no git client to fetch repo://my-org/config@main:compose/remote.yaml",
+		},
+		{
+			"remote not found",
+			Loader{Fetch: fetch},
+			[]string{"repo://my-org/config@release:missing.yaml"},
+			nil,
+			"failed to fetch repo://my-org/config@release:missing.yaml: not found",
+		},
+		{
+			"invalid remote",
+			Loader{Fetch: fetch},
+			[]string{"repo://my-org/config"},
+			nil,
+			"invalid remote configuration repo://my-org/config, must be repo://org/repo@branch:path",
+		},
+	}
+
+	for _, tt := range loadTests {
+		t.Run(tt.name, func(rt *testing.T) {
+			got, err := tt.loader.Load(tt.paths...)
+			if !test.MatchError(rt, tt.wantErr, err) {
+				rt.Fatalf("got error %v, want %s", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(tt.want, got.Repositories); diff != "" {
+				rt.Errorf("Load(%v) failed diff\n%s", tt.paths, diff)
+			}
+		})
+	}
+}
+
+func TestLoaderRemoteReferences(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(secret, []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("YAML_UPDATER_TAG", "v1.2.3")
+	t.Setenv("GIT_AUTH_TOKEN", "s3cr3t")
+	config := func(commitMsg string) string {
+		return `
+repositories:
+  testRepo:
+    sourceRepo: my-org/my-project
+    sourceBranch: main
+    filePath: service-a/deployment.yaml
+    updateKey: spec.template.spec.containers.0.image
+    commitMsg: ` + commitMsg + "\n"
+	}
+	remoteTests := []struct {
+		name      string
+		commitMsg string
+		remoteEnv []string
+		want      string
+		wantErr   string
+	}{
+		{"allowed", "Release ${YAML_UPDATER_TAG}", []string{"YAML_UPDATER_TAG"}, "Release v1.2.3", ""},
+		{"escaped", "Release $${GIT_AUTH_TOKEN}", nil, "Release ${GIT_AUTH_TOKEN}", ""},
+		{"not allowed", "Release ${GIT_AUTH_TOKEN}", []string{"YAML_UPDATER_TAG"}, "", "repositories.testRepo.commitMsg: reference to GIT_AUTH_TOKEN is not allowed"},
+		{"file", "Release ${file:" + secret + "}", []string{"YAML_UPDATER_TAG"}, "", "repositories.testRepo.commitMsg: reference to file:.* is not allowed"},
+	}
+
+	for _, tt := range remoteTests {
+		t.Run(tt.name, func(rt *testing.T) {
+			fetch := func(repo, ref, path string) ([]byte, error) {
+				return []byte(config(tt.commitMsg)), nil
+			}
+			l := Loader{Fetch: fetch, RemoteEnv: tt.remoteEnv}
+
+			got, err := l.Load("repo://my-org/config@main:repositories.yaml")
+			if !test.MatchError(rt, tt.wantErr, err) {
+				rt.Fatalf("got error %v, want %s", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if msg := got.Find("testRepo").CommitMsg; msg != tt.want {
+				rt.Errorf("got commitMsg %q, want %q", msg, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoaderLoadRemoteRestrictions(t *testing.T) {
+	repository := func(templateFile string) string {
+		return `
+repositories:
+  testRepo:
+    sourceRepo: my-org/my-project
+    sourceBranch: main
+    filePath: service-a/deployment.yaml
+    updateKey: spec.template.spec.containers.0.image
+    createMissing: true
+    templateFile: ` + templateFile + "\n"
+	}
+	connection := func(fields string) string {
+		return "connections:\n  internal:\n    driver: gitlab\n" + fields + repository("repo:templates/deployment.yaml")
+	}
+	remote := "repo://my-org/config@main:repositories.yaml"
+	remoteTests := []struct {
+		name              string
+		body              string
+		paths             []string
+		remoteConnections bool
+		wantErr           string
+	}{
+		{"token command", connection("    tokenCommand: cat /secrets/token\n"), nil, false, "connections are not allowed in remote configuration " + remote},
+		{"credential helper", connection("    credentialHelper: '!cat /secrets/token'\n"), nil, false, "connections are not allowed in remote configuration " + remote},
+		{"token sent elsewhere", connection("    endpoint: https://attacker.example.com\n    tokenEnv: GIT_AUTH_TOKEN\n"), nil, false, "connections are not allowed in remote configuration " + remote},
+		{"token file", connection("    tokenFile: /secrets/token\n"), nil, false, "connections are not allowed in remote configuration " + remote},
+		{"CA file", connection("    caFile: /etc/shadow\n"), nil, false, "connections are not allowed in remote configuration " + remote},
+		{"signing key file", connection("    signingKeyFile: /secrets/signing\n"), nil, false, "connections are not allowed in remote configuration " + remote},
+		{"replacing a local connection", connection("    endpoint: https://attacker.example.com\n    tokenEnv: INTERNAL_TOKEN\n"), []string{"testdata/connections/base.yaml"}, false, "connections are not allowed in remote configuration " + remote},
+		{"allowed connections", connection("    tokenEnv: INTERNAL_TOKEN\n"), nil, true, ""},
+		{"local template file", repository("/etc/passwd"), nil, true, "repository testRepo in remote configuration " + remote + ": templateFile /etc/passwd is not a repo: path"},
+		{"repo template file", repository("repo:templates/deployment.yaml"), nil, false, ""},
+	}
+
+	for _, tt := range remoteTests {
+		t.Run(tt.name, func(rt *testing.T) {
+			fetch := func(repo, ref, path string) ([]byte, error) {
+				return []byte(tt.body), nil
+			}
+			l := Loader{Fetch: fetch, RemoteConnections: tt.remoteConnections, AllowOverrides: true}
+
+			_, err := l.Load(append(tt.paths, remote)...)
+			if tt.wantErr == "" && err != nil {
+				rt.Fatalf("Load() failed: %s", err)
+			}
+			if !test.MatchError(rt, tt.wantErr, err) {
+				rt.Fatalf("got error %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoaderLoadConnections(t *testing.T) {
+	got, err := Loader{}.Load("testdata/connections/base.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]*Connection{
+		"internal": {Driver: "gitlab", Endpoint: "https://gitlab.example.com", TokenEnv: "INTERNAL_TOKEN"},
+		"cloud":    {Driver: "bitbucketcloud", Username: "bot", TokenEnv: "CLOUD_TOKEN"},
+	}
+	if diff := cmp.Diff(want, got.Connections); diff != "" {
+		t.Errorf("Load() failed diff\n%s", diff)
+	}
+	if err := got.Validate(); err != nil {
+		t.Errorf("Validate() failed: %s", err)
+	}
+}
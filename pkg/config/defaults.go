@@ -0,0 +1,51 @@
+package config
+
+import (
+	"encoding/json"
+
+	"sigs.k8s.io/yaml"
+)
+
+// mergeDefaults merges the defaults in a YAML configuration into each of its
+// repositories, returning the result as JSON.
+//
+// This is done before decoding into a Repository, so that a value set in a
+// repository wins over the default even if it is false or empty.
+func mergeDefaults(body []byte) ([]byte, error) {
+	j, err := yaml.YAMLToJSON(body)
+	if err != nil {
+		return nil, err
+	}
+	raw := map[string]interface{}{}
+	if err := json.Unmarshal(j, &raw); err != nil {
+		return nil, err
+	}
+	defaults, _ := raw["defaults"].(map[string]interface{})
+	repos, _ := raw["repositories"].(map[string]interface{})
+	for key, repo := range repos {
+		r, _ := repo.(map[string]interface{})
+		if r == nil {
+			r = map[string]interface{}{}
+		}
+		repos[key] = mergeMaps(r, defaults)
+	}
+	return json.Marshal(raw)
+}
+
+// mergeMaps adds to dst the keys in src that dst does not have, merging
+// nested maps.
+func mergeMaps(dst, src map[string]interface{}) map[string]interface{} {
+	for k, v := range src {
+		existing, ok := dst[k]
+		if !ok {
+			dst[k] = v
+			continue
+		}
+		em, eok := existing.(map[string]interface{})
+		vm, vok := v.(map[string]interface{})
+		if eok && vok {
+			dst[k] = mergeMaps(em, vm)
+		}
+	}
+	return dst
+}
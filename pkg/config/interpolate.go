@@ -0,0 +1,123 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// filePrefix starts a reference to the contents of a file, as in
+// ${file:/path/to/secret}.
+const filePrefix = "file:"
+
+// allowFunc returns true if a configuration can reference name, an
+// environment variable or a filePrefix file. A nil allowFunc allows any.
+type allowFunc func(name string) bool
+
+// interpolate expands the references in every string value of a YAML
+// configuration, see expand, returning the result as JSON.
+func interpolate(body []byte, strict bool, allow allowFunc) ([]byte, error) {
+	j, err := yaml.YAMLToJSON(body)
+	if err != nil {
+		return nil, err
+	}
+	var raw interface{}
+	if err := json.Unmarshal(j, &raw); err != nil {
+		return nil, err
+	}
+	raw, err = expandValues("", raw, strict, allow)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// expandValues expands the string values in v, where path is the location of
+// v in the configuration, used in errors.
+func expandValues(path string, v interface{}, strict bool, allow allowFunc) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		s, err := expand(v, strict, allow)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return s, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			expanded, err := expandValues(strings.TrimPrefix(path+"."+k, "."), v[k], strict, allow)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = expanded
+		}
+	case []interface{}:
+		for i := range v {
+			expanded, err := expandValues(fmt.Sprintf("%s[%d]", path, i), v[i], strict, allow)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = expanded
+		}
+	}
+	return v, nil
+}
+
+// expand replaces the ${VAR} references in s with the value of the
+// environment variable, and the ${file:/path} ones with the contents of the
+// file, without trailing newlines. $${ is kept as a literal ${.
+//
+// An undefined environment variable expands to an empty string, unless strict
+// is set, in which case it fails. So does a reference that allow rejects.
+func expand(s string, strict bool, allow allowFunc) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i-1] + "${")
+			s = s[i+2:]
+			continue
+		}
+		end := strings.Index(s[i+2:], "}")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated reference in %q", s)
+		}
+		value, err := lookup(s[i+2:i+2+end], strict, allow)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(s[:i] + value)
+		s = s[i+2+end+1:]
+	}
+}
+
+func lookup(name string, strict bool, allow allowFunc) (string, error) {
+	if allow != nil && !allow(name) {
+		return "", fmt.Errorf("reference to %s is not allowed", name)
+	}
+	if strings.HasPrefix(name, filePrefix) {
+		body, err := ioutil.ReadFile(strings.TrimPrefix(name, filePrefix))
+		if err != nil {
+			return "", fmt.Errorf("failed to read referenced file: %w", err)
+		}
+		return strings.TrimRight(string(body), "\r\n"), nil
+	}
+	value, ok := os.LookupEnv(name)
+	if !ok && strict {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
@@ -0,0 +1,274 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Loader loads repository configurations from several files, and merges them
+// into a single RepoConfiguration.
+type Loader struct {
+	// Strict fails on unknown or duplicated fields.
+	Strict bool
+	// AllowOverrides lets a repository key loaded later replace an earlier one
+	// with the same key, instead of failing.
+	AllowOverrides bool
+	// Fetch reads the configuration files given as RemotePrefix paths, which
+	// fail to load when it is not set or fails.
+	Fetch FetchFunc
+	// RemoteEnv lists the environment variables that RemotePrefix
+	// configurations can reference. Any other reference in them fails,
+	// including to files, so that those who can change a shared
+	// configuration can not read the secrets of the pipelines that load it.
+	RemoteEnv []string
+	// RemoteConnections lets RemotePrefix configurations set connections,
+	// which otherwise fail to load, as a connection can run commands, read
+	// local files and send the tokens of the pipeline to any endpoint.
+	RemoteConnections bool
+}
+
+// Load reads the configuration in each of paths, in order, and merges their
+// repositories into the first one.
+//
+// A path can be a directory, in which case every .yaml and .yml file in it is
+// read in lexical order. The files listed in the include field of a
+// configuration are read before it, relative to its directory, so that its
+// own repositories come last.
+//
+// A path can also refer to a file in a git repository, as in
+// repo://org/repo@branch:path, read with Fetch. Its includes are read from the
+// same repository and branch.
+//
+// It fails if the same repository key is loaded twice, unless AllowOverrides
+// is set, in which case the last one wins. Connections are merged too, and the
+// last one with the same key wins.
+func (l Loader) Load(paths ...string) (*RepoConfiguration, error) {
+	var (
+		merged  *RepoConfiguration
+		sources = map[string]string{}
+	)
+	for _, path := range paths {
+		files := []string{path}
+		if !isRemote(path) {
+			var err error
+			files, err = configFiles(path)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, file := range files {
+			rc, err := l.loadFile(file, map[string]bool{}, sources)
+			if err != nil {
+				return nil, err
+			}
+			if merged == nil {
+				merged = rc
+				continue
+			}
+			for key, repo := range rc.Repositories {
+				merged.Repositories[key] = repo
+			}
+			merged.Connections = mergeConnections(merged.Connections, rc.Connections)
+		}
+	}
+	if merged == nil {
+		return nil, fmt.Errorf("no configuration files found in %v", paths)
+	}
+	return merged, nil
+}
+
+// loadFile reads a file along its includes, where visiting holds the files
+// being loaded to detect include cycles, and sources where each repository
+// key was loaded from.
+func (l Loader) loadFile(path string, visiting map[string]bool, sources map[string]string) (*RepoConfiguration, error) {
+	id, err := configID(path)
+	if err != nil {
+		return nil, err
+	}
+	if visiting[id] {
+		return nil, fmt.Errorf("configuration %s includes itself", path)
+	}
+	visiting[id] = true
+	defer delete(visiting, id)
+
+	body, base, err := l.read(path)
+	if err != nil {
+		return nil, err
+	}
+	var allow allowFunc
+	if isRemote(path) {
+		allow = l.allowRemote
+	}
+	rc, err := parse(bytes.NewReader(body), l.Strict, allow)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if isRemote(path) {
+		if err := l.checkRemote(rc, path); err != nil {
+			return nil, err
+		}
+	}
+
+	repos := map[string]*Repository{}
+	var connections map[string]*Connection
+	for _, include := range rc.Include {
+		matches, err := resolveInclude(base, include)
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			included, err := l.loadFile(match, visiting, sources)
+			if err != nil {
+				return nil, err
+			}
+			for key, repo := range included.Repositories {
+				repos[key] = repo
+			}
+			connections = mergeConnections(connections, included.Connections)
+		}
+	}
+	// In order, so that the same duplicate is reported every time.
+	for _, key := range rc.Keys() {
+		if err := l.addSource(sources, key, path); err != nil {
+			return nil, err
+		}
+		repos[key] = rc.Repositories[key]
+	}
+	rc.Repositories = repos
+	rc.Connections = mergeConnections(connections, rc.Connections)
+	return rc, nil
+}
+
+// allowRemote returns true if name is in RemoteEnv.
+func (l Loader) allowRemote(name string) bool {
+	for _, env := range l.RemoteEnv {
+		if env == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRemote fails if the configuration rc loaded from the remote path sets
+// connections, unless RemoteConnections is set, or reads a template from the
+// local disk, which only repo: template files do not.
+func (l Loader) checkRemote(rc *RepoConfiguration, path string) error {
+	if len(rc.Connections) > 0 && !l.RemoteConnections {
+		return fmt.Errorf("connections are not allowed in remote configuration %s", path)
+	}
+	for _, key := range rc.Keys() {
+		if file := rc.Repositories[key].TemplateFile; file != "" && !strings.HasPrefix(file, "repo:") {
+			return fmt.Errorf("repository %s in remote configuration %s: templateFile %s is not a repo: path", key, path, file)
+		}
+	}
+	return nil
+}
+
+// read returns the contents of the configuration at path, along the path its
+// includes are relative to.
+func (l Loader) read(path string) ([]byte, string, error) {
+	if !isRemote(path) {
+		body, err := ioutil.ReadFile(path)
+		return body, path, err
+	}
+	ref, err := parseRemoteRef(path)
+	if err != nil {
+		return nil, "", err
+	}
+	if l.Fetch == nil {
+		return nil, "", fmt.Errorf("no git client to fetch %s", path)
+	}
+	body, err := l.Fetch(ref.repo, ref.ref, ref.path)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch %s: %w", path, err)
+	}
+	return body, ref.String(), nil
+}
+
+// resolveInclude returns the files that include refers to, relative to the
+// configuration at base.
+func resolveInclude(base, include string) ([]string, error) {
+	if isRemote(include) {
+		return []string{include}, nil
+	}
+	if isRemote(base) {
+		if strings.ContainsAny(include, "*?[") {
+			return nil, fmt.Errorf("include %s in %s: glob patterns are not supported in remote configurations", include, base)
+		}
+		ref, err := parseRemoteRef(base)
+		if err != nil {
+			return nil, err
+		}
+		return []string{ref.join(include).String()}, nil
+	}
+	if !filepath.IsAbs(include) {
+		include = filepath.Join(filepath.Dir(base), include)
+	}
+	matches, err := filepath.Glob(include)
+	if err != nil {
+		return nil, fmt.Errorf("invalid include %s in %s: %w", include, base, err)
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("include %s in %s does not match any file", include, base)
+	}
+	return matches, nil
+}
+
+// configID identifies the configuration at path to detect include cycles.
+func configID(path string) (string, error) {
+	if isRemote(path) {
+		ref, err := parseRemoteRef(path)
+		if err != nil {
+			return "", err
+		}
+		return ref.String(), nil
+	}
+	return filepath.Abs(path)
+}
+
+// mergeConnections adds the connections in src to dst, replacing the ones
+// with the same key.
+func mergeConnections(dst, src map[string]*Connection) map[string]*Connection {
+	if dst == nil && len(src) > 0 {
+		dst = make(map[string]*Connection, len(src))
+	}
+	for key, c := range src {
+		dst[key] = c
+	}
+	return dst
+}
+
+func (l Loader) addSource(sources map[string]string, key, path string) error {
+	if previous, ok := sources[key]; ok && !l.AllowOverrides {
+		return fmt.Errorf("repository %s is defined in both %s and %s", key, previous, path)
+	}
+	sources[key] = path
+	return nil
+}
+
+// configFiles returns path if it is a file, or the YAML files in it if it is
+// a directory.
+func configFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	var files []string
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		matches, err := filepath.Glob(filepath.Join(path, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+	return files, nil
+}
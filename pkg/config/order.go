@@ -0,0 +1,58 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Order returns the repository keys in the order they should be updated,
+// which is sorted by key, except that every repository comes after the ones
+// in its dependsOn. Dependencies on keys that are not in the configuration,
+// e.g. because they were not selected, are ignored.
+//
+// It fails if the dependencies have a cycle.
+func (c RepoConfiguration) Order() ([]string, error) {
+	keys := c.Keys()
+	pending := map[string]int{}
+	dependants := map[string][]string{}
+	for _, key := range keys {
+		repo := c.Repositories[key]
+		if repo == nil {
+			continue
+		}
+		for _, dep := range repo.DependsOn {
+			if _, ok := c.Repositories[dep]; !ok {
+				continue
+			}
+			pending[key]++
+			dependants[dep] = append(dependants[dep], key)
+		}
+	}
+
+	order := make([]string, 0, len(keys))
+	done := map[string]bool{}
+	for len(order) < len(keys) {
+		next := ""
+		for _, key := range keys {
+			if !done[key] && pending[key] == 0 {
+				next = key
+				break
+			}
+		}
+		if next == "" {
+			var cycle []string
+			for _, key := range keys {
+				if !done[key] {
+					cycle = append(cycle, key)
+				}
+			}
+			return nil, fmt.Errorf("dependsOn has a cycle among repositories %s", strings.Join(cycle, ", "))
+		}
+		done[next] = true
+		order = append(order, next)
+		for _, d := range dependants[next] {
+			pending[d]--
+		}
+	}
+	return order, nil
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// RemotePrefix starts a configuration path that refers to a file in a git
+// repository, as in repo://org/repo@branch:path/to/config.yaml.
+const RemotePrefix = "repo://"
+
+// FetchFunc reads the file at path in the branch or ref of repo.
+type FetchFunc func(repo, ref, path string) ([]byte, error)
+
+// remoteRef is a file in a git repository, parsed from a RemotePrefix path.
+type remoteRef struct {
+	repo string
+	ref  string
+	path string
+}
+
+// isRemote returns true if p refers to a file in a git repository.
+func isRemote(p string) bool {
+	return strings.HasPrefix(p, RemotePrefix)
+}
+
+// parseRemoteRef parses repo://org/repo@branch:path, where @branch is
+// optional and defaults to the default branch of the repository.
+func parseRemoteRef(s string) (remoteRef, error) {
+	rest := strings.TrimPrefix(s, RemotePrefix)
+	i := strings.Index(rest, ":")
+	if i < 0 {
+		return remoteRef{}, fmt.Errorf("invalid remote configuration %s, must be %sorg/repo@branch:path", s, RemotePrefix)
+	}
+	r := remoteRef{repo: rest[:i], path: strings.TrimPrefix(rest[i+1:], "/")}
+	if j := strings.Index(r.repo, "@"); j >= 0 {
+		r.repo, r.ref = r.repo[:j], r.repo[j+1:]
+	}
+	if r.repo == "" || r.path == "" {
+		return remoteRef{}, fmt.Errorf("invalid remote configuration %s, must be %sorg/repo@branch:path", s, RemotePrefix)
+	}
+	return r, nil
+}
+
+// join returns the reference to p relative to the directory of r, in the
+// same repository and branch.
+func (r remoteRef) join(p string) remoteRef {
+	if !strings.HasPrefix(p, "/") {
+		p = path.Join(path.Dir(r.path), p)
+	}
+	return remoteRef{repo: r.repo, ref: r.ref, path: strings.TrimPrefix(p, "/")}
+}
+
+func (r remoteRef) String() string {
+	s := RemotePrefix + r.repo
+	if r.ref != "" {
+		s += "@" + r.ref
+	}
+	return s + ":" + r.path
+}
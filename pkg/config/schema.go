@@ -0,0 +1,118 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+const schemaDraft = "http://json-schema.org/draft-07/schema#"
+
+// Schema is a JSON Schema, limited to what is needed to describe a
+// RepoConfiguration.
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties interface{}        `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Definitions          map[string]*Schema `json:"definitions,omitempty"`
+}
+
+// JSONSchema returns the JSON Schema of a RepoConfiguration.
+//
+// It is generated from the struct tags of RepoConfiguration and the types it
+// references: the json tag names each property, the description tag
+// describes it, and the jsonschema tag can mark it as "required", restrict its
+// values with "enum=a|b", or mark it as "partial" to not require any of the
+// fields of the referenced struct.
+func JSONSchema() *Schema {
+	definitions := map[string]*Schema{}
+	root := structSchema(reflect.TypeOf(RepoConfiguration{}), definitions)
+	root.Schema = schemaDraft
+	root.Title = "yaml-updater repositories configuration"
+	root.Definitions = definitions
+	return root
+}
+
+func structSchema(t reflect.Type, definitions map[string]*Schema) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}, AdditionalProperties: false}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, opts := jsonName(f)
+		if name == "" {
+			continue
+		}
+		prop := typeSchema(f.Type, definitions)
+		// Keywords next to a $ref are ignored by draft-07 validators, but
+		// editors still show the description.
+		prop.Description = f.Tag.Get("description")
+		for _, o := range opts {
+			switch {
+			case o == "required":
+				s.Required = append(s.Required, name)
+			case strings.HasPrefix(o, "enum="):
+				prop.Enum = strings.Split(strings.TrimPrefix(o, "enum="), "|")
+			case o == "partial" && prop.Ref != "":
+				def := *definitions[strings.TrimPrefix(prop.Ref, "#/definitions/")]
+				def.Required = nil
+				def.Description = prop.Description
+				prop = &def
+			}
+		}
+		s.Properties[name] = prop
+	}
+	return s
+}
+
+func typeSchema(t reflect.Type, definitions map[string]*Schema) *Schema {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeSchema(t.Elem(), definitions)
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: typeSchema(t.Elem(), definitions)}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: typeSchema(t.Elem(), definitions)}
+	case reflect.Struct:
+		if _, ok := definitions[t.Name()]; !ok {
+			// Reserve the name first, so that recursive types terminate.
+			definitions[t.Name()] = nil
+			definitions[t.Name()] = structSchema(t, definitions)
+		}
+		return &Schema{Ref: "#/definitions/" + t.Name()}
+	}
+	return &Schema{}
+}
+
+// jsonName returns the name of the field in JSON and its jsonschema options,
+// or an empty name if the field is not serialized.
+func jsonName(f reflect.StructField) (string, []string) {
+	if f.PkgPath != "" {
+		return "", nil
+	}
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return "", nil
+	}
+	if name == "" {
+		name = f.Name
+	}
+	var opts []string
+	if tag := f.Tag.Get("jsonschema"); tag != "" {
+		opts = strings.Split(tag, ",")
+	}
+	return name, opts
+}
@@ -0,0 +1,173 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Selector selects repositories by their labels. It is parsed from comma
+// separated requirements, all of which must match:
+//
+//	env=prod           label env is prod
+//	env!=prod          label env is not prod, or is not set
+//	env in (dev,qa)    label env is dev or qa
+//	env notin (dev,qa) label env is neither dev nor qa, or is not set
+//	env                label env is set
+//	!env               label env is not set
+//
+// Values can be glob patterns, as in team=pay*.
+type Selector []requirement
+
+type requirement struct {
+	key    string
+	op     string
+	values []string
+}
+
+const (
+	opEquals    = "="
+	opNotEquals = "!="
+	opIn        = "in"
+	opNotIn     = "notin"
+	opExists    = "exists"
+	opNotExists = "!"
+)
+
+var setRequirement = regexp.MustCompile(`^([^\s!=(),]+)\s+(in|notin)\s+\((.*)\)$`)
+
+// ParseSelector parses a Selector from its string representation.
+func ParseSelector(s string) (Selector, error) {
+	var sel Selector
+	for _, term := range splitTerms(s) {
+		r, err := parseRequirement(strings.TrimSpace(term))
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %w", s, err)
+		}
+		sel = append(sel, r)
+	}
+	return sel, nil
+}
+
+// splitTerms splits s at the commas that are not within parentheses.
+func splitTerms(s string) []string {
+	var (
+		terms []string
+		depth int
+		start int
+	)
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				terms = append(terms, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(terms, s[start:])
+}
+
+func parseRequirement(term string) (requirement, error) {
+	var r requirement
+	switch {
+	case term == "":
+		return r, fmt.Errorf("empty requirement")
+	case setRequirement.MatchString(term):
+		m := setRequirement.FindStringSubmatch(term)
+		r = requirement{key: m[1], op: m[2]}
+		for _, v := range strings.Split(m[3], ",") {
+			r.values = append(r.values, strings.TrimSpace(v))
+		}
+	case strings.Contains(term, "!="):
+		parts := strings.SplitN(term, "!=", 2)
+		r = requirement{key: parts[0], op: opNotEquals, values: []string{parts[1]}}
+	case strings.Contains(term, "="):
+		parts := strings.SplitN(strings.Replace(term, "==", "=", 1), "=", 2)
+		r = requirement{key: parts[0], op: opEquals, values: []string{parts[1]}}
+	case strings.HasPrefix(term, "!"):
+		r = requirement{key: strings.TrimPrefix(term, "!"), op: opNotExists}
+	default:
+		r = requirement{key: term, op: opExists}
+	}
+	r.key = strings.TrimSpace(r.key)
+	if r.key == "" || strings.ContainsAny(r.key, " \t!=(),") {
+		return r, fmt.Errorf("invalid label key in %q", term)
+	}
+	for i, v := range r.values {
+		r.values[i] = strings.TrimSpace(v)
+		if _, err := path.Match(r.values[i], ""); err != nil {
+			return r, fmt.Errorf("invalid pattern %q in %q: %w", v, term, err)
+		}
+	}
+	return r, nil
+}
+
+// Matches returns true if labels match every requirement in the selector.
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		if !r.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r requirement) matches(labels map[string]string) bool {
+	value, ok := labels[r.key]
+	switch r.op {
+	case opExists:
+		return ok
+	case opNotExists:
+		return !ok
+	case opEquals, opIn:
+		return ok && matchAny(r.values, value)
+	case opNotEquals, opNotIn:
+		return !ok || !matchAny(r.values, value)
+	}
+	return false
+}
+
+func matchAny(patterns []string, value string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Select returns the sorted keys of the repositories whose labels match the
+// selector.
+func (c RepoConfiguration) Select(s Selector) []string {
+	var keys []string
+	for key, repo := range c.Repositories {
+		if repo != nil && s.Matches(repo.Labels) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Match returns the sorted repository keys that match the glob pattern.
+func (c RepoConfiguration) Match(pattern string) ([]string, error) {
+	var keys []string
+	for key := range c.Repositories {
+		ok, err := path.Match(pattern, key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		if ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
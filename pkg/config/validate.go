@@ -0,0 +1,154 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ValidationError holds every problem found when validating a
+// RepoConfiguration.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid repositories config:\n  %s", strings.Join(e.Problems, "\n  "))
+}
+
+// Validate checks the repository for missing required fields and conflicting
+// options, returning a description of each problem found.
+func (r Repository) Validate() []string {
+	var problems []string
+	// These are not required in the JSON Schema, as defaults can set them.
+	required := []struct{ name, value string }{
+		{"sourceRepo", r.SourceRepo},
+		{"sourceBranch", r.SourceBranch},
+		{"filePath", r.FilePath},
+	}
+	for _, f := range required {
+		if f.value == "" {
+			problems = append(problems, fmt.Sprintf("%s is required", f.name))
+		}
+	}
+	if r.UpdateKey == "" && !r.RemoveFile && r.CopyFrom == "" && r.MoveTo == "" {
+		problems = append(problems, "updateKey is required unless removeFile, copyFrom or moveTo are set")
+	}
+	if r.RemoveKey && r.RemoveFile {
+		problems = append(problems, "removeKey and removeFile can not be used together")
+	}
+	if r.CopyFrom != "" && r.MoveTo != "" {
+		problems = append(problems, "copyFrom and moveTo can not be used together")
+	}
+	if r.RemoveFile && (r.CopyFrom != "" || r.MoveTo != "") {
+		problems = append(problems, "removeFile can not be used along copyFrom or moveTo")
+	}
+	if r.MoveTo != "" && r.MoveTo == r.FilePath {
+		problems = append(problems, "moveTo must be different from filePath")
+	}
+	if (r.Template != "" || r.TemplateFile != "") && !r.CreateMissing {
+		problems = append(problems, "template and templateFile require createMissing")
+	}
+	if r.BranchNaming == "template" && r.BranchTemplate == "" {
+		problems = append(problems, "branchNaming template requires branchTemplate")
+	}
+	if r.BranchTemplate != "" && r.BranchNaming != "" && r.BranchNaming != "template" {
+		problems = append(problems, fmt.Sprintf("branchTemplate has no effect with branchNaming %s", r.BranchNaming))
+	}
+	if r.RemoveKey && r.ValueFrom != "" {
+		problems = append(problems, "valueFrom has no effect with removeKey")
+	}
+	if r.RemoveKey && r.Value != "" {
+		problems = append(problems, "value has no effect with removeKey")
+	}
+	return append(problems, enumProblems(r)...)
+}
+
+// Validate checks the connection for values its fields do not allow,
+// returning a description of each problem found.
+func (c Connection) Validate() []string {
+	return enumProblems(c)
+}
+
+// enumProblems returns a problem for each field of v, a struct, that is set
+// to a value not in the enum of its jsonschema tag, as the schema would.
+func enumProblems(v interface{}) []string {
+	var problems []string
+	rv := reflect.ValueOf(v)
+	for i := 0; i < rv.NumField(); i++ {
+		name, opts := jsonName(rv.Type().Field(i))
+		if name == "" || rv.Field(i).Kind() != reflect.String || rv.Field(i).String() == "" {
+			continue
+		}
+		value := rv.Field(i).String()
+		for _, o := range opts {
+			if !strings.HasPrefix(o, "enum=") {
+				continue
+			}
+			allowed := strings.Split(strings.TrimPrefix(o, "enum="), "|")
+			known := false
+			for _, a := range allowed {
+				known = known || a == value
+			}
+			if !known {
+				problems = append(problems, fmt.Sprintf("unknown %s %s, must be one of %s", name, value, strings.Join(allowed, ", ")))
+			}
+		}
+	}
+	return problems
+}
+
+// Validate checks every repository in the configuration, and the references
+// between them, returning a ValidationError with all the problems found.
+func (c RepoConfiguration) Validate() error {
+	var problems []string
+	if len(c.Repositories) == 0 {
+		problems = append(problems, "no repositories configured")
+	}
+	keys := c.Keys()
+	for _, key := range keys {
+		repo := c.Repositories[key]
+		if repo == nil {
+			problems = append(problems, fmt.Sprintf("%s: repository is empty", key))
+			continue
+		}
+		for _, p := range repo.Validate() {
+			problems = append(problems, fmt.Sprintf("%s: %s", key, p))
+		}
+		if repo.ValueFrom == key {
+			problems = append(problems, fmt.Sprintf("%s: valueFrom can not reference the repository itself", key))
+		} else if repo.ValueFrom != "" && c.Find(repo.ValueFrom) == nil {
+			problems = append(problems, fmt.Sprintf("%s: valueFrom references unknown repository %s", key, repo.ValueFrom))
+		}
+		if _, ok := c.Connections[repo.Connection]; repo.Connection != "" && !ok {
+			problems = append(problems, fmt.Sprintf("%s: connection references unknown connection %s", key, repo.Connection))
+		}
+		for _, dep := range repo.DependsOn {
+			if c.Find(dep) == nil {
+				problems = append(problems, fmt.Sprintf("%s: dependsOn references unknown repository %s", key, dep))
+			}
+		}
+	}
+	names := make([]string, 0, len(c.Connections))
+	for name := range c.Connections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		conn := c.Connections[name]
+		if conn == nil {
+			continue
+		}
+		for _, p := range conn.Validate() {
+			problems = append(problems, fmt.Sprintf("connection %s: %s", name, p))
+		}
+	}
+	if _, err := c.Order(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
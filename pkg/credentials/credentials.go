@@ -0,0 +1,174 @@
+// Package credentials provides the credentials to authenticate with a Git
+// service, from files, commands or git credential helpers, and redacts them
+// from the output.
+package credentials
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Credentials authenticate requests to a Git service.
+type Credentials struct {
+	// Username is empty unless the source provides it.
+	Username string
+	Token    string
+}
+
+// Source provides Credentials when they are needed.
+type Source interface {
+	Credentials() (Credentials, error)
+}
+
+// Static is a token that does not change.
+type Static string
+
+// Credentials implements Source.
+func (s Static) Credentials() (Credentials, error) {
+	AddSecret(string(s))
+	return Credentials{Token: string(s)}, nil
+}
+
+// File is the path to a file with a token, read again each time it is
+// needed, so that it can be rotated, e.g. when mounted from a Kubernetes
+// secret.
+type File string
+
+// Credentials implements Source.
+func (f File) Credentials() (Credentials, error) {
+	b, err := ioutil.ReadFile(string(f))
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to read the token file: %w", err)
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return Credentials{}, fmt.Errorf("the token file %s is empty", f)
+	}
+	AddSecret(token)
+	return Credentials{Token: token}, nil
+}
+
+// Command is a shell command that prints a token. It is only run the first
+// time the token is needed.
+type Command struct {
+	Command string
+
+	once cache
+}
+
+// Credentials implements Source.
+func (c *Command) Credentials() (Credentials, error) {
+	return c.once.get(func() (Credentials, error) {
+		out, err := run(c.Command, "")
+		if err != nil {
+			return Credentials{}, fmt.Errorf("failed to run the token command: %w", err)
+		}
+		token := strings.TrimSpace(out)
+		if token == "" {
+			return Credentials{}, fmt.Errorf("the token command printed no token")
+		}
+		AddSecret(token)
+		return Credentials{Token: token}, nil
+	})
+}
+
+// Helper is a git credential helper, see gitcredentials(7), asked for the
+// credentials of a URL. It is only run the first time they are needed.
+type Helper struct {
+	// Helper is given as in the credential.helper setting of git: the name
+	// of a git-credential-<name> command, e.g. store, an absolute path, or a
+	// shell snippet starting with !.
+	Helper string
+	// URL is the URL of the Git service.
+	URL string
+
+	once cache
+}
+
+// Credentials implements Source. The password given by the helper is used as
+// the token.
+func (h *Helper) Credentials() (Credentials, error) {
+	return h.once.get(func() (Credentials, error) {
+		u, err := url.Parse(h.URL)
+		if err != nil {
+			return Credentials{}, fmt.Errorf("invalid URL for the credential helper: %w", err)
+		}
+		out, err := run(helperCommand(h.Helper), fmt.Sprintf("protocol=%s\nhost=%s\n\n", u.Scheme, u.Host))
+		if err != nil {
+			return Credentials{}, fmt.Errorf("failed to run the credential helper %s: %w", h.Helper, err)
+		}
+		var c Credentials
+		s := bufio.NewScanner(strings.NewReader(out))
+		for s.Scan() {
+			kv := strings.SplitN(s.Text(), "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch kv[0] {
+			case "username":
+				c.Username = kv[1]
+			case "password":
+				c.Token = kv[1]
+			}
+		}
+		if c.Token == "" {
+			return Credentials{}, fmt.Errorf("the credential helper %s gave no password for %s", h.Helper, u.Host)
+		}
+		AddSecret(c.Token)
+		return c, nil
+	})
+}
+
+// helperCommand returns the shell command to get credentials from a helper,
+// as git does.
+func helperCommand(helper string) string {
+	switch {
+	case strings.HasPrefix(helper, "!"):
+		return strings.TrimPrefix(helper, "!") + " get"
+	case strings.HasPrefix(helper, "/"):
+		return helper + " get"
+	}
+	return "git credential-" + helper + " get"
+}
+
+// run runs command with sh, writing input to it, and returns its output.
+func run(command, input string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, Redact(msg))
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
+
+// cache keeps the first Credentials obtained successfully.
+type cache struct {
+	mu          sync.Mutex
+	credentials *Credentials
+}
+
+func (c *cache) get(f func() (Credentials, error)) (Credentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.credentials != nil {
+		return *c.credentials, nil
+	}
+	creds, err := f()
+	if err != nil {
+		return Credentials{}, err
+	}
+	c.credentials = &creds
+	return creds, nil
+}
@@ -0,0 +1,118 @@
+package credentials
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/ocraviotto/yaml-updater/test"
+)
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	f := File(path)
+	var got []string
+	for _, token := range []string{"first-token\n", "rotated-token\n"} {
+		if err := ioutil.WriteFile(path, []byte(token), 0600); err != nil {
+			t.Fatal(err)
+		}
+		c, err := f.Credentials()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, c.Token)
+	}
+
+	if diff := cmp.Diff([]string{"first-token", "rotated-token"}, got); diff != "" {
+		t.Errorf("Credentials() failed diff\n%s", diff)
+	}
+}
+
+func TestSources(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty")
+	if err := ioutil.WriteFile(empty, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	sourceTests := []struct {
+		name    string
+		source  Source
+		want    Credentials
+		wantErr string
+	}{
+		{"static", Static("static-token"), Credentials{Token: "static-token"}, ""},
+		{"missing file", File(filepath.Join(dir, "missing")), Credentials{}, "failed to read the token file: .*"},
+		{"empty file", File(empty), Credentials{}, "the token file .* is empty"},
+		{"command", &Command{Command: "echo command-token"}, Credentials{Token: "command-token"}, ""},
+		{"failing command", &Command{Command: "echo denied >&2; exit 1"}, Credentials{}, "failed to run the token command: exit status 1: denied"},
+		{"silent command", &Command{Command: "true"}, Credentials{}, "the token command printed no token"},
+		{
+			"helper",
+			&Helper{Helper: `!f() { test "$1" = get && grep -q host=gitlab.example.com && printf 'username=bot\npassword=helper-token\n'; }; f`, URL: "https://gitlab.example.com/api/v4"},
+			Credentials{Username: "bot", Token: "helper-token"},
+			"",
+		},
+		{
+			"helper without password",
+			&Helper{Helper: "!echo username=bot", URL: "https://gitlab.example.com"},
+			Credentials{},
+			"the credential helper !echo username=bot gave no password for gitlab.example.com",
+		},
+		{
+			"missing helper",
+			&Helper{Helper: "/missing/helper", URL: "https://gitlab.example.com"},
+			Credentials{},
+			"failed to run the credential helper /missing/helper: exit status 127: .*",
+		},
+	}
+
+	for _, tt := range sourceTests {
+		t.Run(tt.name, func(rt *testing.T) {
+			got, err := tt.source.Credentials()
+			if !test.MatchError(rt, tt.wantErr, err) {
+				rt.Fatalf("got error %v, want %s", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got, cmpopts.EquateEmpty()); diff != "" {
+				rt.Errorf("Credentials() failed diff\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCommandRunsOnce(t *testing.T) {
+	count := filepath.Join(t.TempDir(), "count")
+	c := &Command{Command: "echo run >> " + count + "; echo command-token"}
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.Credentials(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := ioutil.ReadFile(count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(b); s != "run\n" {
+		t.Errorf("command ran %q, want once", s)
+	}
+}
+
+func TestHelperCommand(t *testing.T) {
+	helperTests := []struct {
+		helper string
+		want   string
+	}{
+		{"store", "git credential-store get"},
+		{"/usr/local/bin/helper --flag", "/usr/local/bin/helper --flag get"},
+		{"!vault-helper", "vault-helper get"},
+	}
+
+	for _, tt := range helperTests {
+		if got := helperCommand(tt.helper); got != tt.want {
+			t.Errorf("helperCommand(%q) got %q, want %q", tt.helper, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package credentials
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces the secrets in the output.
+const Redacted = "[REDACTED]"
+
+// minSecretLength is the length below which secrets are not redacted, as
+// they would match too much of the output.
+const minSecretLength = 4
+
+var secrets = struct {
+	sync.RWMutex
+	values []string
+}{}
+
+// AddSecret adds a value to redact from the output.
+func AddSecret(s string) {
+	if len(s) < minSecretLength {
+		return
+	}
+	secrets.Lock()
+	defer secrets.Unlock()
+	for _, v := range secrets.values {
+		if v == s {
+			return
+		}
+	}
+	secrets.values = append(secrets.values, s)
+	// The longest first, so that a secret containing another is replaced
+	// whole.
+	sort.Slice(secrets.values, func(i, j int) bool {
+		return len(secrets.values[i]) > len(secrets.values[j])
+	})
+}
+
+// Redact replaces the secrets in s with Redacted.
+func Redact(s string) string {
+	secrets.RLock()
+	defer secrets.RUnlock()
+	for _, v := range secrets.values {
+		s = strings.ReplaceAll(s, v, Redacted)
+	}
+	return s
+}
+
+// RedactWriter returns a Writer that writes to w with the secrets redacted.
+// Each write should be a whole line, or else a secret split across writes is
+// not redacted.
+func RedactWriter(w io.Writer) io.Writer {
+	return &redactWriter{w: w}
+}
+
+type redactWriter struct {
+	w io.Writer
+}
+
+func (r *redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
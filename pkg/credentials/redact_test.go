@@ -0,0 +1,28 @@
+package credentials
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRedactWriter(t *testing.T) {
+	AddSecret("abc")
+	AddSecret("secret-token")
+	AddSecret("secret-token-long")
+	var b bytes.Buffer
+	w := RedactWriter(&b)
+
+	line := `{"msg":"failed","token":"secret-token-long","other":"secret-token","short":"abc"}` + "\n"
+	n, err := w.Write([]byte(line))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != len(line) {
+		t.Errorf("Write() got %d, want %d", n, len(line))
+	}
+	want := `{"msg":"failed","token":"[REDACTED]","other":"[REDACTED]","short":"abc"}` + "\n"
+	if s := b.String(); s != want {
+		t.Errorf("got %q, want %q", s, want)
+	}
+}
@@ -0,0 +1,58 @@
+package credentials
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Transport is an http.RoundTripper that authenticates each request with the
+// credentials from Source, as the go-scm driver named Driver expects.
+type Transport struct {
+	Driver string
+	// Username is used instead of the one Source provides, if any.
+	Username string
+	Source   Source
+	// Base is the RoundTripper making the requests, http.DefaultTransport if
+	// nil.
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c, err := t.Source.Credentials()
+	if err != nil {
+		closeBody(req)
+		return nil, err
+	}
+	if t.Username != "" {
+		c.Username = t.Username
+	}
+	r := req.Clone(req.Context())
+	switch t.Driver {
+	case "gitea":
+		r.Header.Set("Authorization", "token "+c.Token)
+	case "gitlab":
+		r.Header.Set("Private-Token", c.Token)
+	case "bitbucketcloud":
+		if c.Username == "" {
+			closeBody(req)
+			return nil, fmt.Errorf("no username supplied for bitbucketcloud")
+		}
+		r.SetBasicAuth(c.Username, c.Token)
+	default:
+		r.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(r)
+}
+
+// closeBody closes the body of a request that is not sent, as a RoundTripper
+// must.
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
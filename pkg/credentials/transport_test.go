@@ -0,0 +1,66 @@
+package credentials
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ocraviotto/yaml-updater/test"
+)
+
+func TestTransport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, h := range []string{"Authorization", "Private-Token"} {
+			if v := r.Header.Get(h); v != "" {
+				w.Header().Set("X-"+h, v)
+			}
+		}
+	}))
+	defer ts.Close()
+	transportTests := []struct {
+		driver   string
+		username string
+		source   Source
+		want     http.Header
+		wantErr  string
+	}{
+		{"github", "", Static("gh-token"), http.Header{"X-Authorization": {"Bearer gh-token"}}, ""},
+		{"gitea", "", Static("gt-token"), http.Header{"X-Authorization": {"token gt-token"}}, ""},
+		{"gitlab", "", Static("gl-token"), http.Header{"X-Private-Token": {"gl-token"}}, ""},
+		{"bitbucketcloud", "bot", Static("bb-token"), http.Header{"X-Authorization": {"Basic Ym90OmJiLXRva2Vu"}}, ""},
+		{"bitbucketcloud", "", Static("bb-token"), nil, ".*no username supplied for bitbucketcloud"},
+		{"github", "", File("testdata/missing"), nil, ".*failed to read the token file: .*"},
+	}
+
+	for _, tt := range transportTests {
+		t.Run(tt.driver, func(rt *testing.T) {
+			c := &http.Client{Transport: &Transport{Driver: tt.driver, Username: tt.username, Source: tt.source}}
+			req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+			if err != nil {
+				rt.Fatal(err)
+			}
+
+			resp, err := c.Do(req)
+			if !test.MatchError(rt, tt.wantErr, err) {
+				rt.Fatalf("got error %v, want %s", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			resp.Body.Close()
+			got := http.Header{}
+			for k, v := range resp.Header {
+				if k == "X-Authorization" || k == "X-Private-Token" {
+					got[k] = v
+				}
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				rt.Errorf("headers diff\n%s", diff)
+			}
+			if len(req.Header) != 0 {
+				rt.Errorf("the request was modified: %v", req.Header)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+// Package githubapp authenticates requests to GitHub as an installation of a
+// GitHub App.
+package githubapp
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// DefaultBaseURL is the GitHub API URL used when none is configured.
+const DefaultBaseURL = "https://api.github.com/"
+
+// jwtLifetime is how long the app JWTs are valid for, GitHub allows up to 10
+// minutes.
+const jwtLifetime = 9 * time.Minute
+
+// Config is a GitHub App installation to authenticate as.
+type Config struct {
+	AppID          int64
+	InstallationID int64
+	// Key is the private key of the app.
+	Key *rsa.PrivateKey
+	// BaseURL is the GitHub API URL, e.g. https://ghe.example.com/api/v3/,
+	// DefaultBaseURL if empty.
+	BaseURL string
+	// Client makes the requests for installation tokens, http.DefaultClient
+	// if nil.
+	Client *http.Client
+
+	now func() time.Time
+}
+
+// TokenSource returns a TokenSource for installation tokens, that mints a new
+// one when the last one is about to expire.
+func (c Config) TokenSource() oauth2.TokenSource {
+	return oauth2.ReuseTokenSource(nil, &tokenSource{config: c})
+}
+
+// JWT returns a JSON Web Token that authenticates as the app, signed with its
+// private key.
+func (c Config) JWT(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	// Backdated to allow for clock drift, as GitHub recommends.
+	claims, err := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(jwtLifetime).Unix(),
+		"iss": strconv.FormatInt(c.AppID, 10),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, c.Key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign the GitHub App JWT: %w", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+type tokenSource struct {
+	config Config
+}
+
+// Token implements oauth2.TokenSource by creating an installation token.
+func (s *tokenSource) Token() (*oauth2.Token, error) {
+	c := s.config
+	now := time.Now
+	if c.now != nil {
+		now = c.now
+	}
+	jwt, err := c.JWT(now())
+	if err != nil {
+		return nil, err
+	}
+	baseURL := c.BaseURL
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	url := fmt.Sprintf("%s/app/installations/%d/access_tokens", strings.TrimSuffix(baseURL, "/"), c.InstallationID)
+	req, err := http.NewRequest(http.MethodPost, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a token for installation %d of GitHub App %d: %w", c.InstallationID, c.AppID, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a token for installation %d of GitHub App %d: %w", c.InstallationID, c.AppID, err)
+	}
+	var result struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+		Message   string    `json:"message"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil && resp.StatusCode == http.StatusCreated {
+		return nil, fmt.Errorf("failed to parse the token for installation %d of GitHub App %d: %w", c.InstallationID, c.AppID, err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		msg := result.Message
+		if msg == "" {
+			msg = http.StatusText(resp.StatusCode)
+		}
+		return nil, fmt.Errorf("failed to create a token for installation %d of GitHub App %d: %d %s", c.InstallationID, c.AppID, resp.StatusCode, msg)
+	}
+	return &oauth2.Token{AccessToken: result.Token, Expiry: result.ExpiresAt}, nil
+}
+
+// ParsePrivateKey parses a PEM encoded RSA private key, in PKCS #1 form as
+// GitHub generates them, or in PKCS #8 form.
+func ParsePrivateKey(b []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM encoded private key found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the private key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("the private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// LoadPrivateKey reads the private key in the file at path, see
+// ParsePrivateKey.
+func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the GitHub App private key: %w", err)
+	}
+	key, err := ParsePrivateKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GitHub App private key in %s: %w", path, err)
+	}
+	return key, nil
+}
@@ -0,0 +1,145 @@
+package githubapp
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ocraviotto/yaml-updater/test"
+)
+
+func TestTokenSource(t *testing.T) {
+	key := generateKey(t)
+	now := time.Date(2021, time.March, 1, 12, 0, 0, 0, time.UTC)
+	var requests []string
+	expires := []time.Time{time.Now().Add(-time.Minute), time.Now().Add(time.Hour)}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		claims := verifyJWT(t, &key.PublicKey, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		want := map[string]interface{}{
+			"iat": float64(now.Add(-time.Minute).Unix()),
+			"exp": float64(now.Add(jwtLifetime).Unix()),
+			"iss": "1234",
+		}
+		if diff := cmp.Diff(want, claims); diff != "" {
+			t.Errorf("JWT claims diff\n%s", diff)
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"token": "token-%d", "expires_at": %q}`, len(requests), expires[len(requests)-1].Format(time.RFC3339))
+	}))
+	defer ts.Close()
+	src := Config{AppID: 1234, InstallationID: 5678, Key: key, BaseURL: ts.URL + "/api/v3/", now: func() time.Time { return now }}.TokenSource()
+
+	var tokens []string
+	for i := 0; i < 3; i++ {
+		tok, err := src.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, tok.AccessToken)
+	}
+
+	// The first token is already expired, and so refreshed.
+	if diff := cmp.Diff([]string{"token-1", "token-2", "token-2"}, tokens); diff != "" {
+		t.Errorf("tokens diff\n%s", diff)
+	}
+	want := []string{
+		"POST /api/v3/app/installations/5678/access_tokens",
+		"POST /api/v3/app/installations/5678/access_tokens",
+	}
+	if diff := cmp.Diff(want, requests); diff != "" {
+		t.Errorf("requests diff\n%s", diff)
+	}
+}
+
+func TestTokenSourceWithError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"message": "Not Found"}`)
+	}))
+	defer ts.Close()
+	src := Config{AppID: 1234, InstallationID: 5678, Key: generateKey(t), BaseURL: ts.URL}.TokenSource()
+
+	_, err := src.Token()
+
+	if !test.MatchError(t, "failed to create a token for installation 5678 of GitHub App 1234: 404 Not Found", err) {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	key := generateKey(t)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parseTests := []struct {
+		name    string
+		pem     []byte
+		wantErr string
+	}{
+		{"PKCS #1", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), ""},
+		{"PKCS #8", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}), ""},
+		{"not PEM", []byte("not a key"), "no PEM encoded private key found"},
+		{"invalid key", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("invalid")}), "failed to parse the private key: .*"},
+	}
+
+	for _, tt := range parseTests {
+		t.Run(tt.name, func(rt *testing.T) {
+			got, err := ParsePrivateKey(tt.pem)
+			if !test.MatchError(rt, tt.wantErr, err) {
+				rt.Fatalf("got error %v, want %s", err, tt.wantErr)
+			}
+			if err == nil && !key.Equal(got) {
+				rt.Errorf("ParsePrivateKey() got a different key")
+			}
+		})
+	}
+}
+
+func generateKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// verifyJWT checks the RS256 signature of a JWT and returns its claims.
+func verifyJWT(t *testing.T, key *rsa.PublicKey, jwt string) map[string]interface{} {
+	t.Helper()
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		t.Fatalf("invalid JWT %q", jwt)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig); err != nil {
+		t.Fatalf("invalid JWT signature: %s", err)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := map[string]interface{}{}
+	if err := json.Unmarshal(b, &claims); err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
@@ -0,0 +1,396 @@
+// Package gitproto reads and commits files with the git protocol, over HTTPS
+// or SSH, for Git services without a usable contents API.
+package gitproto
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ocraviotto/go-scm/scm"
+	"github.com/ocraviotto/pkg/client"
+
+	"github.com/ocraviotto/yaml-updater/pkg/credentials"
+)
+
+// RepoPlaceholder is replaced with the name of the repository in the URL of a
+// Client.
+const RepoPlaceholder = "{repo}"
+
+// DefaultSignature is the author of the commits that have no signature.
+var DefaultSignature = scm.Signature{Name: "yaml-updater", Email: "yaml-updater@localhost"}
+
+// Client is a client.GitClient that works on shallow clones of the
+// repositories, made with the git command in a temporary directory, instead of
+// with the API of the Git service. Only pull requests need the API.
+//
+// Call Close to remove the clones.
+type Client struct {
+	// URL is the template of the URL to clone a repository from, where
+	// {repo} is replaced with its name, e.g. https://git.example.com/{repo}.git
+	// or ssh://git@git.example.com/{repo}.git.
+	URL string
+	// Credentials authenticate the requests to HTTPS URLs, if not nil.
+	Credentials credentials.Source
+	// Username is used with the token of Credentials, instead of the one
+	// Credentials provides, if any, or else x-access-token.
+	Username string
+	// SSHKeyFile is the private key to authenticate to SSH URLs with, the
+	// ones ssh is configured with if empty.
+	SSHKeyFile string
+	// KnownHostsFile has the keys to verify SSH servers with, the
+	// known_hosts file of ssh if empty.
+	KnownHostsFile string
+	// PullRequests creates the pull requests, usually with the API of the
+	// Git service. Creating them fails if nil.
+	PullRequests client.GitClient
+	// Signing signs the commits, if not nil.
+	Signing *Signing
+
+	mu     sync.Mutex
+	dir    string
+	repos  map[string]string
+	signer *signer
+}
+
+var _ client.GitClient = (*Client)(nil)
+
+// GetBranchHead implements client.GitClient by fetching the branch.
+func (c *Client) GetBranchHead(ctx context.Context, repo, branch string) (string, error) {
+	g, err := c.open(ctx, repo)
+	if err != nil {
+		return "", err
+	}
+	return g.fetch(ctx, branch)
+}
+
+// GetFile implements client.GitClient. The Sha of the content is the one of
+// its blob, as in the GitHub API.
+func (c *Client) GetFile(ctx context.Context, repo, ref, path string) (*scm.Content, error) {
+	g, err := c.open(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := g.fetch(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := g.entry(ctx, commit, path)
+	if err != nil {
+		return nil, err
+	}
+	if entry.sha == "" {
+		return &scm.Content{}, client.SCMError{Msg: fmt.Sprintf("failed to get file %s from repo %s ref %s", path, repo, ref), Status: http.StatusNotFound}
+	}
+	data, err := g.run(ctx, nil, "cat-file", "blob", entry.sha)
+	if err != nil {
+		return nil, err
+	}
+	return &scm.Content{Path: path, Data: data, Sha: entry.sha}, nil
+}
+
+// CreateBranch implements client.GitClient by pushing sha, which must have
+// been fetched with GetBranchHead, to the new branch.
+func (c *Client) CreateBranch(ctx context.Context, repo, branch, sha string) error {
+	g, err := c.open(ctx, repo)
+	if err != nil {
+		return err
+	}
+	_, err = g.run(ctx, nil, "push", "--quiet", g.url, sha+":refs/heads/"+branch)
+	return err
+}
+
+// UpdateFile implements client.GitClient by committing content on top of the
+// branch and pushing it.
+//
+// When the file exists and its blob is not previousSHA, or the branch moved
+// while committing, it fails with a 409 Conflict SCMError, as the APIs do.
+func (c *Client) UpdateFile(ctx context.Context, repo, branch, path, message, previousSHA string, signature scm.Signature, content []byte) error {
+	return c.commit(ctx, repo, branch, path, message, previousSHA, signature, content, false)
+}
+
+// DeleteFile implements client.GitClient as UpdateFile does.
+func (c *Client) DeleteFile(ctx context.Context, repo, branch, path, message, previousSHA string, signature scm.Signature, content []byte) error {
+	return c.commit(ctx, repo, branch, path, message, previousSHA, signature, nil, true)
+}
+
+// CreatePullRequest implements client.GitClient with PullRequests.
+func (c *Client) CreatePullRequest(ctx context.Context, repo string, inp *scm.PullRequestInput) (*scm.PullRequest, error) {
+	if c.PullRequests == nil {
+		return nil, fmt.Errorf("creating pull requests is not supported without an API for the Git service, disable them with disablePRCreation")
+	}
+	return c.PullRequests.CreatePullRequest(ctx, repo, inp)
+}
+
+// Close removes the clones.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dir == "" {
+		return nil
+	}
+	if c.signer != nil {
+		c.signer.close()
+	}
+	err := os.RemoveAll(c.dir)
+	c.dir, c.repos, c.signer = "", nil, nil
+	return err
+}
+
+func (c *Client) commit(ctx context.Context, repo, branch, path, message, previousSHA string, signature scm.Signature, content []byte, remove bool) error {
+	action := "update"
+	if remove {
+		action = "delete"
+	}
+	conflict := client.SCMError{Msg: fmt.Sprintf("failed to %s file %s in repo %s branch %s", action, path, repo, branch), Status: http.StatusConflict}
+	g, err := c.open(ctx, repo)
+	if err != nil {
+		return err
+	}
+	parent, err := g.fetch(ctx, branch)
+	if err != nil {
+		return err
+	}
+	entry, err := g.entry(ctx, parent, path)
+	if err != nil {
+		return err
+	}
+	if entry.sha != "" && previousSHA != "" && entry.sha != previousSHA {
+		return conflict
+	}
+	index := filepath.Join(g.dir, "index-"+strconv.Itoa(os.Getpid()))
+	defer os.Remove(index)
+	env := []string{"GIT_INDEX_FILE=" + index}
+	if _, err := g.run(ctx, env, "read-tree", parent); err != nil {
+		return err
+	}
+	if remove {
+		// A zero mode removes the entry, as update-index --force-remove
+		// would, which needs a work tree.
+		_, err = g.runInput(ctx, env, []byte("0 "+strings.Repeat("0", 40)+"\t"+path+"\n"), "update-index", "--index-info")
+	} else {
+		var blob []byte
+		blob, err = g.runInput(ctx, nil, content, "hash-object", "-w", "--stdin")
+		if err != nil {
+			return err
+		}
+		mode := entry.mode
+		if mode == "" {
+			mode = "100644"
+		}
+		_, err = g.run(ctx, env, "update-index", "--add", "--cacheinfo", mode+","+strings.TrimSpace(string(blob))+","+path)
+	}
+	if err != nil {
+		return err
+	}
+	tree, err := g.run(ctx, env, "write-tree")
+	if err != nil {
+		return err
+	}
+	if signature.Name == "" || signature.Email == "" {
+		signature = DefaultSignature
+	}
+	env = []string{
+		"GIT_AUTHOR_NAME=" + signature.Name, "GIT_AUTHOR_EMAIL=" + signature.Email,
+		"GIT_COMMITTER_NAME=" + signature.Name, "GIT_COMMITTER_EMAIL=" + signature.Email,
+	}
+	args := []string{"commit-tree", strings.TrimSpace(string(tree)), "-p", parent}
+	if g.sign {
+		args = append(args, "-S")
+	}
+	commit, err := g.runInput(ctx, env, []byte(message), args...)
+	if err != nil {
+		return err
+	}
+	_, err = g.run(ctx, nil, "push", "--quiet", g.url, strings.TrimSpace(string(commit))+":refs/heads/"+branch)
+	if err != nil && isRejected(err) {
+		return conflict
+	}
+	return err
+}
+
+// open returns the clone of repo, initializing it if needed.
+func (c *Client) open(ctx context.Context, repo string) (*clone, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var err error
+	if c.dir == "" {
+		if err := checkGitVersion(ctx, c.Signing != nil && c.Signing.Format == SigningSSH); err != nil {
+			return nil, err
+		}
+		if c.dir, err = ioutil.TempDir("", "yaml-updater-"); err != nil {
+			return nil, err
+		}
+		c.repos = map[string]string{}
+	}
+	if c.Signing != nil && c.signer == nil {
+		if c.signer, err = c.Signing.prepare(ctx, filepath.Join(c.dir, "signing")); err != nil {
+			return nil, err
+		}
+	}
+	env, err := c.env()
+	if err != nil {
+		return nil, err
+	}
+	g := &clone{url: strings.Replace(c.URL, RepoPlaceholder, repo, 1), env: env, sign: c.signer != nil}
+	if dir, ok := c.repos[repo]; ok {
+		g.dir = dir
+		return g, nil
+	}
+	g.dir = filepath.Join(c.dir, strconv.Itoa(len(c.repos)))
+	if _, err := g.run(ctx, nil, "init", "--quiet", "--bare", g.dir); err != nil {
+		return nil, err
+	}
+	c.repos[repo] = g.dir
+	return g, nil
+}
+
+// env returns the environment variables that configure git to authenticate
+// and sign as set in c, without prompting.
+func (c *Client) env() ([]string, error) {
+	env := []string{"GIT_TERMINAL_PROMPT=0"}
+	var config []string
+	if c.Credentials != nil && strings.HasPrefix(c.URL, "http") {
+		creds, err := c.Credentials.Credentials()
+		if err != nil {
+			return nil, err
+		}
+		username := c.Username
+		if username == "" {
+			username = creds.Username
+		}
+		if username == "" {
+			username = "x-access-token"
+		}
+		basic := base64.StdEncoding.EncodeToString([]byte(username + ":" + creds.Token))
+		credentials.AddSecret(basic)
+		config = append(config, "http.extraHeader", "Authorization: Basic "+basic)
+	}
+	if c.SSHKeyFile != "" || c.KnownHostsFile != "" {
+		ssh := "ssh"
+		if c.SSHKeyFile != "" {
+			ssh += " -i " + shellQuote(c.SSHKeyFile) + " -o IdentitiesOnly=yes"
+		}
+		if c.KnownHostsFile != "" {
+			ssh += " -o UserKnownHostsFile=" + shellQuote(c.KnownHostsFile) + " -o StrictHostKeyChecking=yes"
+		}
+		env = append(env, "GIT_SSH_COMMAND="+ssh)
+	}
+	if c.signer != nil {
+		env = append(env, c.signer.env...)
+		config = append(config, c.signer.config...)
+	}
+	// Given in the environment, and not as arguments, to keep the
+	// credentials out of the process list.
+	env = append(env, "GIT_CONFIG_COUNT="+strconv.Itoa(len(config)/2))
+	for i := 0; i < len(config); i += 2 {
+		env = append(env, fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i/2, config[i]), fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i/2, config[i+1]))
+	}
+	return env, nil
+}
+
+// clone is a bare repository that the objects of a remote one are fetched
+// into.
+type clone struct {
+	dir  string
+	url  string
+	env  []string
+	sign bool
+}
+
+// fetch fetches the commit that ref, a branch or a commit SHA, points to, and
+// returns its SHA.
+func (g *clone) fetch(ctx context.Context, ref string) (string, error) {
+	refspec := "+refs/heads/" + ref + ":refs/heads/" + ref
+	local := "refs/heads/" + ref
+	if isSHA(ref) {
+		if _, err := g.run(ctx, nil, "cat-file", "-e", ref+"^{commit}"); err == nil {
+			return ref, nil
+		}
+		refspec, local = ref, ref
+	}
+	if _, err := g.run(ctx, nil, "fetch", "--quiet", "--depth=1", "--no-tags", g.url, refspec); err != nil {
+		if strings.Contains(err.Error(), "couldn't find remote ref") || strings.Contains(err.Error(), "not our ref") {
+			return "", client.SCMError{Msg: fmt.Sprintf("failed to get ref %s in repo %s", ref, g.url), Status: http.StatusNotFound}
+		}
+		return "", err
+	}
+	sha, err := g.run(ctx, nil, "rev-parse", "--verify", local+"^{commit}")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(sha)), nil
+}
+
+// treeEntry is a file in a commit, with no sha if it does not exist.
+type treeEntry struct {
+	mode string
+	sha  string
+}
+
+func (g *clone) entry(ctx context.Context, commit, path string) (treeEntry, error) {
+	out, err := g.run(ctx, nil, "ls-tree", commit, "--", path)
+	if err != nil {
+		return treeEntry{}, err
+	}
+	// <mode> SP <type> SP <sha> TAB <path>
+	fields := strings.Fields(string(out))
+	if len(fields) < 3 || fields[1] != "blob" {
+		return treeEntry{}, nil
+	}
+	return treeEntry{mode: fields[0], sha: fields[2]}, nil
+}
+
+func (g *clone) run(ctx context.Context, env []string, args ...string) ([]byte, error) {
+	return g.runInput(ctx, env, nil, args...)
+}
+
+// runInput runs git in the clone, with input as its standard input and env
+// added to its environment, and returns its output.
+func (g *clone) runInput(ctx context.Context, env []string, input []byte, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	if args[0] != "init" {
+		cmd.Env = append(append(append(os.Environ(), "GIT_DIR="+g.dir), g.env...), env...)
+	}
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("git %s failed: %s", args[0], credentials.Redact(strings.TrimSpace(stderr.String())))
+	}
+	return stdout.Bytes(), nil
+}
+
+func isRejected(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "[rejected]") || strings.Contains(msg, "non-fast-forward") || strings.Contains(msg, "fetch first")
+}
+
+func isSHA(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,118 @@
+package gitproto
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/ocraviotto/yaml-updater/pkg/credentials"
+)
+
+// Signing formats.
+const (
+	SigningGPG = "gpg"
+	SigningSSH = "ssh"
+)
+
+// Signing signs the commits with a GPG or SSH key, so that Git services can
+// show them as verified.
+type Signing struct {
+	// Format is SigningGPG or SigningSSH.
+	Format string
+	// KeyFile is the path to the private key, without a passphrase: an ASCII
+	// armored GPG secret key, or an SSH private key.
+	KeyFile string
+	// Key is the private key itself, instead of KeyFile, e.g. from an
+	// environment variable.
+	Key string
+}
+
+// signer is a Signing prepared for git to use.
+type signer struct {
+	env    []string
+	config []string
+	// gnupgHome is the home of gpg that the key is imported into, if any.
+	gnupgHome string
+}
+
+// prepare makes the key of s available to git, with dir for any files needed.
+func (s *Signing) prepare(ctx context.Context, dir string) (*signer, error) {
+	key := []byte(s.Key)
+	if s.KeyFile != "" {
+		b, err := ioutil.ReadFile(s.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the signing key: %w", err)
+		}
+		key = b
+	}
+	if len(bytes.TrimSpace(key)) == 0 {
+		return nil, fmt.Errorf("no signing key given")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	switch s.Format {
+	case SigningSSH:
+		// ssh-keygen refuses keys that others can read, and needs them to end
+		// with a newline.
+		keyFile := filepath.Join(dir, "id")
+		if err := ioutil.WriteFile(keyFile, append(bytes.TrimSpace(key), '\n'), 0600); err != nil {
+			return nil, err
+		}
+		return &signer{config: []string{"gpg.format", "ssh", "user.signingKey", keyFile}}, nil
+	case SigningGPG, "":
+		home := filepath.Join(dir, "gnupg")
+		if err := os.Mkdir(home, 0700); err != nil {
+			return nil, err
+		}
+		env := []string{"GNUPGHOME=" + home}
+		if _, err := gpg(ctx, env, key, "--import"); err != nil {
+			return nil, fmt.Errorf("failed to import the signing key: %w", err)
+		}
+		out, err := gpg(ctx, env, nil, "--list-secret-keys", "--with-colons")
+		if err != nil {
+			return nil, err
+		}
+		fingerprint := ""
+		for _, line := range strings.Split(string(out), "\n") {
+			if fields := strings.Split(line, ":"); fields[0] == "fpr" && len(fields) > 9 {
+				fingerprint = fields[9]
+				break
+			}
+		}
+		if fingerprint == "" {
+			return nil, fmt.Errorf("no secret key found in the signing key")
+		}
+		return &signer{
+			env:       env,
+			config:    []string{"gpg.format", "openpgp", "user.signingKey", fingerprint},
+			gnupgHome: home,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown signing format %s, must be gpg or ssh", s.Format)
+}
+
+// close stops the gpg agent started for the key, if any.
+func (s *signer) close() {
+	if s.gnupgHome != "" {
+		_ = exec.Command("gpgconf", "--homedir", s.gnupgHome, "--kill", "gpg-agent").Run()
+	}
+}
+
+func gpg(ctx context.Context, env []string, input []byte, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "gpg", append([]string{"--batch", "--no-tty"}, args...)...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("gpg failed: %s", credentials.Redact(strings.TrimSpace(stderr.String())))
+	}
+	return stdout.Bytes(), nil
+}
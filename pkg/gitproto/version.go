@@ -0,0 +1,61 @@
+package gitproto
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+)
+
+// gitVersion is a major and minor version of git.
+type gitVersion struct {
+	major, minor int
+}
+
+func (v gitVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.major, v.minor)
+}
+
+func (v gitVersion) before(o gitVersion) bool {
+	return v.major < o.major || (v.major == o.major && v.minor < o.minor)
+}
+
+var (
+	// minGitVersion is the first version with GIT_CONFIG_COUNT, that the
+	// credentials and signing are configured with.
+	minGitVersion = gitVersion{2, 31}
+	// minSSHSigningVersion is the first version that signs with SSH keys.
+	minSSHSigningVersion = gitVersion{2, 34}
+
+	versionPattern = regexp.MustCompile(`^git version (\d+)\.(\d+)`)
+)
+
+// checkGitVersion returns an error if git is not installed, or is older than
+// needed, with SSH signing if ssh is true.
+func checkGitVersion(ctx context.Context, ssh bool) error {
+	out, err := exec.CommandContext(ctx, "git", "version").Output()
+	if err != nil {
+		return fmt.Errorf("the git backend needs git %s or later installed: %w", minGitVersion, err)
+	}
+	return requireGitVersion(string(out), ssh)
+}
+
+// requireGitVersion returns an error if the output of git version is older
+// than needed, with SSH signing if ssh is true.
+func requireGitVersion(out string, ssh bool) error {
+	m := versionPattern.FindStringSubmatch(out)
+	if m == nil {
+		return fmt.Errorf("failed to parse the git version from %q", out)
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	v := gitVersion{major, minor}
+	if v.before(minGitVersion) {
+		return fmt.Errorf("the git backend needs git %s or later, found %s", minGitVersion, v)
+	}
+	if ssh && v.before(minSSHSigningVersion) {
+		return fmt.Errorf("signing with SSH keys needs git %s or later, found %s", minSSHSigningVersion, v)
+	}
+	return nil
+}
@@ -1,13 +1,15 @@
 package names
 
 import (
-	"fmt"
 	"math/rand"
 )
 
 // RandomGenerator generates a random name prefix.
 type RandomGenerator struct {
 	rand *rand.Rand
+	// MaxLength is the length that names are shortened to, DefaultMaxLength
+	// if 0.
+	MaxLength int
 }
 
 // New creates and returns a RandomGenerator.
@@ -16,14 +18,28 @@ func New(r *rand.Rand) *RandomGenerator {
 }
 
 // PrefixedName generates a name from the prefix with an additional 5 random
-// alphabetic characters.
-// TODO: this should limit the length based on the prefix because branch names
-// have a limit.
+// alphabetic characters. The prefix is sanitized, and shortened so that the
+// name fits in MaxLength.
 func (g RandomGenerator) PrefixedName(prefix string) string {
 	charset := "abcdefghijklmnopqrstuvwyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 	b := make([]byte, 5)
 	for i := range b {
 		b[i] = charset[g.rand.Intn(len(charset))]
 	}
-	return fmt.Sprintf("%s%s", prefix, b)
+	return join(prefix, string(b), g.MaxLength)
+}
+
+// UpdateName implements UpdateGenerator with a random name prefixed with the
+// prefix of u.
+func (g RandomGenerator) UpdateName(u Update) (string, error) {
+	return g.PrefixedName(u.Prefix), nil
+}
+
+// Fixed is a Generator that always generates the same name, e.g. one from an
+// UpdateGenerator for a Generator to use.
+type Fixed string
+
+// PrefixedName returns f, whatever the prefix.
+func (f Fixed) PrefixedName(string) string {
+	return string(f)
 }
@@ -14,3 +14,19 @@ func TestGenerator(t *testing.T) {
 		t.Fatalf("got %v, want %v", name, "testing-DlPsU")
 	}
 }
+
+func TestGeneratorMaxLength(t *testing.T) {
+	g := RandomGenerator{rand: rand.New(rand.NewSource(100)), MaxLength: 13}
+
+	name := g.PrefixedName("testing:long-")
+
+	if name != "testing-DlPsU" {
+		t.Fatalf("got %v, want %v", name, "testing-DlPsU")
+	}
+}
+
+func TestFixed(t *testing.T) {
+	if name := Fixed("gitops/a").PrefixedName("testing-"); name != "gitops/a" {
+		t.Fatalf("got %v, want %v", name, "gitops/a")
+	}
+}
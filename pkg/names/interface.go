@@ -4,3 +4,20 @@ package names
 type Generator interface {
 	PrefixedName(s string) string
 }
+
+// Update is what the branch for an update is named after.
+type Update struct {
+	// Prefix is the branchGenerateName of the repository.
+	Prefix string
+	// Key is the key of the repository in the configuration.
+	Key   string
+	Name  string
+	Repo  string
+	Value string
+}
+
+// UpdateGenerator is implemented by values that generate the name of the
+// branch for an update.
+type UpdateGenerator interface {
+	UpdateName(u Update) (string, error)
+}
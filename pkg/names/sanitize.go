@@ -0,0 +1,70 @@
+package names
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultMaxLength is the length that generated names are shortened to by
+// default, well within the limits of Git services.
+const DefaultMaxLength = 100
+
+// invalidRefChars matches what git does not allow in branch names, see
+// git check-ref-format.
+var invalidRefChars = regexp.MustCompile(`[\x00-\x20\x7f~^:?*\[\\]+|\.\.+|@\{`)
+
+// Sanitize replaces what git does not allow in a branch name with "-", removes
+// empty path components and the dots and ".lock" suffixes they can not start
+// or end with, and shortens name to max bytes, if above 0.
+func Sanitize(name string, max int) string {
+	name = invalidRefChars.ReplaceAllString(name, "-")
+	name = truncate(name, max)
+	var components []string
+	for _, c := range strings.Split(name, "/") {
+		for {
+			trimmed := strings.TrimSuffix(strings.Trim(c, "."), ".lock")
+			if trimmed == c {
+				break
+			}
+			c = trimmed
+		}
+		if c != "" {
+			components = append(components, c)
+		}
+	}
+	name = strings.TrimLeft(strings.Join(components, "/"), "-")
+	if name == "@" {
+		return ""
+	}
+	return name
+}
+
+// join returns the sanitized prefix followed by suffix, shortening the prefix
+// so that the name fits in max bytes, DefaultMaxLength if 0.
+func join(prefix, suffix string, max int) string {
+	if max <= 0 {
+		max = DefaultMaxLength
+	}
+	suffix = Sanitize(suffix, max)
+	return Sanitize(truncate(prefix, max-len(suffix))+suffix, max)
+}
+
+// WithSuffix returns name followed by "-" and suffix, shortening name so that
+// the result fits in DefaultMaxLength.
+func WithSuffix(name, suffix string) string {
+	suffix = Sanitize(suffix, DefaultMaxLength)
+	return join(truncate(name, DefaultMaxLength-len(suffix)-1)+"-", suffix, 0)
+}
+
+// truncate shortens s to max bytes, if above 0, without splitting a rune.
+func truncate(s string, max int) string {
+	if max <= 0 || len(s) <= max {
+		return s
+	}
+	s = s[:max]
+	for !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
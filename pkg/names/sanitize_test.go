@@ -0,0 +1,51 @@
+package names
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitize(t *testing.T) {
+	sanitizeTests := []struct {
+		name string
+		max  int
+		want string
+	}{
+		{"gitops-", 0, "gitops-"},
+		{"gitops/service-a/v1.1.0", 0, "gitops/service-a/v1.1.0"},
+		{"gitops/quay.io/my-image:v1.1.0", 0, "gitops/quay.io/my-image-v1.1.0"},
+		{"update image ~^?*[x]\\", 0, "update-image-x]-"},
+		{"a..b@{c}", 0, "a-b-c}"},
+		{"/gitops//.hidden/x.lock/", 0, "gitops/hidden/x"},
+		{"-gitops.", 0, "gitops"},
+		{"gitops/service-a", 8, "gitops/s"},
+		{"gitops/a.lock", 13, "gitops/a"},
+		{"gitops/é", 8, "gitops"},
+		{"@", 0, ""},
+	}
+
+	for _, tt := range sanitizeTests {
+		if got := Sanitize(tt.name, tt.max); got != tt.want {
+			t.Errorf("Sanitize(%q, %d) got %q, want %q", tt.name, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestWithSuffix(t *testing.T) {
+	long := strings.Repeat("a", DefaultMaxLength)
+	suffixTests := []struct {
+		name   string
+		suffix string
+		want   string
+	}{
+		{"gitops-0123456789", "980a0d5", "gitops-0123456789-980a0d5"},
+		{"gitops/service-a", "v1:0", "gitops/service-a-v1-0"},
+		{long, "980a0d5", long[:DefaultMaxLength-8] + "-980a0d5"},
+	}
+
+	for _, tt := range suffixTests {
+		if got := WithSuffix(tt.name, tt.suffix); got != tt.want {
+			t.Errorf("WithSuffix(%q, %q) got %q, want %q", tt.name, tt.suffix, got, tt.want)
+		}
+	}
+}
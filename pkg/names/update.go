@@ -0,0 +1,100 @@
+package names
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// hashLength is the number of hex characters of the hash in a name.
+const hashLength = 10
+
+// Hash generates the same name for the same update, the prefix followed by a
+// hash of the repository key and the value, so that an update can reuse the
+// branch of a previous run.
+type Hash struct {
+	// MaxLength is the length that names are shortened to, DefaultMaxLength
+	// if 0.
+	MaxLength int
+}
+
+// UpdateName implements UpdateGenerator.
+func (g Hash) UpdateName(u Update) (string, error) {
+	sum := sha256.Sum256([]byte(u.Key + "\x00" + u.Value))
+	return join(u.Prefix, hex.EncodeToString(sum[:])[:hashLength], g.MaxLength), nil
+}
+
+// timestampLayout is the layout of the time in a name.
+const timestampLayout = "20060102-150405"
+
+// Timestamp generates a name from the prefix followed by the current time in
+// UTC.
+type Timestamp struct {
+	// MaxLength is the length that names are shortened to, DefaultMaxLength
+	// if 0.
+	MaxLength int
+	// Now returns the current time, time.Now if nil.
+	Now func() time.Time
+}
+
+// UpdateName implements UpdateGenerator.
+func (g Timestamp) UpdateName(u Update) (string, error) {
+	now := time.Now
+	if g.Now != nil {
+		now = g.Now
+	}
+	return join(u.Prefix, now().UTC().Format(timestampLayout), g.MaxLength), nil
+}
+
+// Template generates names by rendering a Go template with the Update, e.g.
+// "gitops/{{.Key}}/{{.Value | slug}}".
+type Template struct {
+	// MaxLength is the length that names are shortened to, DefaultMaxLength
+	// if 0.
+	MaxLength int
+
+	t *template.Template
+}
+
+// NewTemplate parses text into a Template.
+//
+// Besides the builtin functions, the template can call slug, which lowercases
+// a string and replaces anything but ASCII letters and digits with "-".
+func NewTemplate(text string) (*Template, error) {
+	t, err := template.New("branch").Option("missingkey=error").Funcs(template.FuncMap{"slug": Slug}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the branch name template: %w", err)
+	}
+	return &Template{t: t}, nil
+}
+
+// UpdateName implements UpdateGenerator.
+func (g *Template) UpdateName(u Update) (string, error) {
+	var b bytes.Buffer
+	if err := g.t.Execute(&b, u); err != nil {
+		return "", fmt.Errorf("failed to render the branch name template: %w", err)
+	}
+	max := g.MaxLength
+	if max <= 0 {
+		max = DefaultMaxLength
+	}
+	name := Sanitize(b.String(), max)
+	if name == "" {
+		return "", fmt.Errorf("the branch name template rendered %q, which is not a valid branch name", b.String())
+	}
+	return name, nil
+}
+
+var notSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Slug lowercases s and replaces anything but ASCII letters and digits with
+// "-", e.g. "quay.io/my-org/my-image:v1.1.0" becomes
+// "quay-io-my-org-my-image-v1-1-0".
+func Slug(s string) string {
+	return strings.Trim(notSlugChars.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
@@ -0,0 +1,88 @@
+package names
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ocraviotto/yaml-updater/test"
+)
+
+var testUpdate = Update{Prefix: "gitops-", Key: "service-a", Name: "my-image", Repo: "my-org/my-repo", Value: "quay.io/my-org/my-image:v1.1.0"}
+
+func TestUpdateGenerators(t *testing.T) {
+	tmpl, err := NewTemplate("gitops/{{.Key}}/{{.Value | slug}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := func() time.Time {
+		return time.Date(2021, time.March, 4, 15, 4, 5, 0, time.FixedZone("CET", 3600))
+	}
+	generatorTests := []struct {
+		name      string
+		generator UpdateGenerator
+		update    Update
+		want      string
+	}{
+		{"hash", Hash{}, testUpdate, "gitops-5377771687"},
+		{"hash of another value", Hash{}, Update{Prefix: "gitops-", Key: "service-a", Value: "quay.io/my-org/my-image:v1.2.0"}, "gitops-d832e54f25"},
+		{"hash max length", Hash{MaxLength: 14}, testUpdate, "gito5377771687"},
+		{"timestamp", Timestamp{Now: now}, testUpdate, "gitops-20210304-140405"},
+		{"template", tmpl, testUpdate, "gitops/service-a/quay-io-my-org-my-image-v1-1-0"},
+		{"template max length", &Template{MaxLength: 20, t: tmpl.t}, testUpdate, "gitops/service-a/qua"},
+	}
+
+	for _, tt := range generatorTests {
+		t.Run(tt.name, func(rt *testing.T) {
+			got, err := tt.generator.UpdateName(tt.update)
+			if err != nil {
+				rt.Fatal(err)
+			}
+			if got != tt.want {
+				rt.Errorf("UpdateName() got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHashIsDeterministic(t *testing.T) {
+	a, _ := Hash{}.UpdateName(testUpdate)
+	b, _ := Hash{}.UpdateName(testUpdate)
+	if a != b {
+		t.Fatalf("got %q and %q for the same update", a, b)
+	}
+}
+
+func TestTemplateErrors(t *testing.T) {
+	_, err := NewTemplate("gitops/{{.Key")
+	if !test.MatchError(t, "failed to parse the branch name template.*", err) {
+		t.Fatalf("got error %v", err)
+	}
+
+	tmpl, err := NewTemplate("{{.Missing}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tmpl.UpdateName(testUpdate)
+	if !test.MatchError(t, "failed to render the branch name template.*", err) {
+		t.Fatalf("got error %v", err)
+	}
+
+	tmpl, err = NewTemplate("{{.Prefix | printf \"%.0s\"}}..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tmpl.UpdateName(testUpdate)
+	if !test.MatchError(t, `the branch name template rendered "\.\.", which is not a valid branch name`, err) {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+func TestSlug(t *testing.T) {
+	if got := Slug(" Quay.io/My-Org/my_image:V1.1.0 "); got != "quay-io-my-org-my-image-v1-1-0" {
+		t.Fatalf("got %q", got)
+	}
+	if got := Slug(strings.Repeat("-", 3)); got != "" {
+		t.Fatalf("got %q", got)
+	}
+}
@@ -0,0 +1,78 @@
+// Package retry retries operations with exponential backoff.
+package retry
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Backoff configures how an operation is retried.
+type Backoff struct {
+	// Attempts is the maximum number of attempts, including the first one.
+	// Less than 2 disables retries.
+	Attempts int
+	// Delay is the delay before the first retry, doubled on each one.
+	Delay time.Duration
+	// MaxDelay caps the delay between attempts, when not zero.
+	MaxDelay time.Duration
+	// Timeout caps the total time spent retrying, when not zero. No retry is
+	// attempted if it would start after the timeout.
+	Timeout time.Duration
+}
+
+// DefaultBackoff is used when no Backoff is configured.
+var DefaultBackoff = Backoff{
+	Attempts: 4,
+	Delay:    time.Second,
+	MaxDelay: 30 * time.Second,
+	Timeout:  2 * time.Minute,
+}
+
+// delay returns the delay before the given retry, counting from 1.
+func (b Backoff) delay(retry int) time.Duration {
+	d := b.Delay
+	for i := 1; i < retry && (b.MaxDelay == 0 || d < b.MaxDelay); i++ {
+		d *= 2
+	}
+	if b.MaxDelay > 0 && d > b.MaxDelay {
+		return b.MaxDelay
+	}
+	return d
+}
+
+// Do calls f until it succeeds, returns an error for which retryable is
+// false, or the attempts or the timeout of b are exhausted, in which case it
+// returns the last error.
+func Do(ctx context.Context, b Backoff, retryable func(error) bool, f func() error) error {
+	deadline := time.Time{}
+	if b.Timeout > 0 {
+		deadline = time.Now().Add(b.Timeout)
+	}
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || !retryable(err) || attempt >= b.Attempts {
+			return err
+		}
+		d := b.delay(attempt)
+		if !deadline.IsZero() && time.Now().Add(d).After(deadline) {
+			return err
+		}
+		if serr := Sleep(ctx, d); serr != nil {
+			return fmt.Errorf("%v (stopped retrying: %w)", err, serr)
+		}
+	}
+}
+
+// Sleep waits for d, or until ctx is done, in which case it returns the error
+// of ctx.
+func Sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
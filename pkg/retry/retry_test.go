@@ -0,0 +1,67 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ocraviotto/yaml-updater/test"
+)
+
+var errRetryable = errors.New("retryable")
+
+func TestDo(t *testing.T) {
+	b := Backoff{Attempts: 3, Delay: time.Millisecond}
+	doTests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   string
+	}{
+		{"success", []error{nil}, 1, ""},
+		{"retried", []error{errRetryable, errRetryable, nil}, 3, ""},
+		{"not retryable", []error{errors.New("failure"), nil}, 1, "failure"},
+		{"attempts exhausted", []error{errRetryable, errRetryable, errRetryable, nil}, 3, "retryable"},
+	}
+
+	for _, tt := range doTests {
+		t.Run(tt.name, func(rt *testing.T) {
+			calls := 0
+			err := Do(context.Background(), b, func(err error) bool { return errors.Is(err, errRetryable) }, func() error {
+				calls++
+				return tt.errs[calls-1]
+			})
+			if !test.MatchError(rt, tt.wantErr, err) {
+				rt.Fatalf("got error %v, want %s", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				rt.Fatalf("got %d calls, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestDoWithCancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b := Backoff{Attempts: 3, Delay: time.Hour}
+
+	err := Do(ctx, b, func(error) bool { return true }, func() error { return errRetryable })
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Delay: time.Second, MaxDelay: 5 * time.Second}
+	var got []time.Duration
+	for i := 1; i <= 5; i++ {
+		got = append(got, b.delay(i))
+	}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("delay failed diff\n%s", diff)
+	}
+}
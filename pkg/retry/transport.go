@@ -0,0 +1,99 @@
+package retry
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Transport is an http.RoundTripper that retries the requests that get a 429
+// Too Many Requests or a 5xx response, waiting as their Retry-After header
+// says, or else as the Backoff does.
+//
+// As a 500, 502 or 504 response does not tell whether the request was
+// processed, those are only retried for idempotent methods, e.g. not when
+// creating a pull request.
+type Transport struct {
+	// Base is the RoundTripper making the requests, http.DefaultTransport if
+	// nil.
+	Base    http.RoundTripper
+	Backoff Backoff
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	deadline := time.Time{}
+	if t.Backoff.Timeout > 0 {
+		deadline = time.Now().Add(t.Backoff.Timeout)
+	}
+	r := req
+	for attempt := 1; ; attempt++ {
+		resp, err := base.RoundTrip(r)
+		if err != nil || !shouldRetry(req, resp) || attempt >= t.Backoff.Attempts {
+			return resp, err
+		}
+		if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+			return resp, nil
+		}
+		d, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now())
+		if !ok {
+			d = t.Backoff.delay(attempt)
+		}
+		if !deadline.IsZero() && time.Now().Add(d).After(deadline) {
+			return resp, nil
+		}
+		r = req.Clone(req.Context())
+		if req.GetBody != nil {
+			if r.Body, err = req.GetBody(); err != nil {
+				return resp, nil
+			}
+		}
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if err := Sleep(req.Context(), d); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func shouldRetry(req *http.Request, resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return isIdempotent(req.Method)
+	}
+	return false
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// retryAfter parses a Retry-After header, given in seconds or as a date,
+// into the delay from now.
+func retryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if s, err := strconv.Atoi(v); err == nil && s >= 0 {
+		return time.Duration(s) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
@@ -0,0 +1,83 @@
+package retry
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransport(t *testing.T) {
+	transportTests := []struct {
+		name       string
+		method     string
+		statuses   []int
+		wantStatus int
+		wantCalls  int
+	}{
+		{"success", http.MethodGet, []int{200}, 200, 1},
+		{"too many requests", http.MethodPost, []int{429, 201}, 201, 2},
+		{"unavailable", http.MethodPost, []int{503, 503, 201}, 201, 3},
+		{"server error on get", http.MethodGet, []int{500, 200}, 200, 2},
+		{"server error on post", http.MethodPost, []int{500, 201}, 500, 1},
+		{"attempts exhausted", http.MethodGet, []int{502, 502, 502, 200}, 502, 3},
+		{"not found", http.MethodGet, []int{404, 200}, 404, 1},
+	}
+
+	for _, tt := range transportTests {
+		t.Run(tt.name, func(rt *testing.T) {
+			calls := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				if r.Method == http.MethodPost && string(body) != "payload" {
+					rt.Errorf("got body %q, want payload", body)
+				}
+				calls++
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(tt.statuses[calls-1])
+			}))
+			defer ts.Close()
+			c := &http.Client{Transport: &Transport{Backoff: Backoff{Attempts: 3, Delay: time.Hour}}}
+
+			req, err := http.NewRequest(tt.method, ts.URL, strings.NewReader("payload"))
+			if err != nil {
+				rt.Fatal(err)
+			}
+			resp, err := c.Do(req)
+			if err != nil {
+				rt.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				rt.Fatalf("got status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if calls != tt.wantCalls {
+				rt.Fatalf("got %d calls, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	retryAfterTests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"", 0, false},
+		{"120", 2 * time.Minute, true},
+		{"Tue, 01 Jun 2021 10:00:30 GMT", 30 * time.Second, true},
+		{"Tue, 01 Jun 2021 09:00:00 GMT", 0, true},
+		{"soon", 0, false},
+	}
+
+	for _, tt := range retryAfterTests {
+		got, ok := retryAfter(tt.value, now)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("retryAfter(%q) got %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package tracking
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ocraviotto/go-scm/scm"
+)
+
+// Cleanup actions.
+const (
+	ActionKeep    = "keep"
+	ActionDelete  = "delete"
+	ActionDeleted = "deleted"
+	ActionFailed  = "failed"
+)
+
+// CleanupOptions configures Cleanup.
+type CleanupOptions struct {
+	// OlderThan is the age of the last commit after which a branch without
+	// a pull request is deleted. Such branches are kept if 0.
+	OlderThan time.Duration
+	// CloseStale closes the open pull request of a branch whose last commit
+	// is older than OlderThan too, and deletes the branch. Branches with an
+	// open pull request are kept otherwise.
+	CloseStale bool
+	// DryRun reports what would be deleted, with ActionDelete, without
+	// deleting anything.
+	DryRun bool
+	// Now returns the current time, time.Now if nil.
+	Now func() time.Time
+}
+
+// CleanedBranch is what Cleanup did with a branch, and why.
+type CleanedBranch struct {
+	Keys        []string `json:"keys"`
+	Repo        string   `json:"sourceRepo"`
+	Branch      string   `json:"branch"`
+	PullRequest int      `json:"pullRequest,omitempty"`
+	Action      string   `json:"action"`
+	Reason      string   `json:"reason"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// Cleanup deletes the branches of t whose latest pull request is merged or
+// closed, and those without a pull request whose last commit is older than
+// opts.OlderThan. Branches with an open pull request are kept, unless
+// opts.CloseStale is set and their last commit is older than opts.OlderThan,
+// in which case the pull request is closed before deleting them.
+//
+// It returns what was done with each branch of t, and an error if the
+// branches or pull requests can not be listed. A failure to close a pull
+// request or delete a branch is reported with ActionFailed.
+func Cleanup(ctx context.Context, s Service, t Target, opts CleanupOptions) ([]CleanedBranch, error) {
+	now := time.Now
+	if opts.Now != nil {
+		now = opts.Now
+	}
+	branches, err := t.branches(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	prs, err := pullRequestsBySource(ctx, s, t.Repo)
+	if err != nil {
+		return nil, err
+	}
+	cleaned := make([]CleanedBranch, 0, len(branches))
+	for _, ref := range branches {
+		b := CleanedBranch{Keys: t.Keys, Repo: t.Repo, Branch: ref.Name, Action: ActionKeep}
+		var open bool
+		switch latest := prs[ref.Name]; {
+		case len(latest) > 0:
+			pr := latest[0]
+			b.PullRequest = pr.Number
+			b.Reason = fmt.Sprintf("pull request #%d is %s", pr.Number, State(pr))
+			if pr.Merged || pr.Closed {
+				b.Action = ActionDelete
+				break
+			}
+			open = true
+			if opts.CloseStale && opts.OlderThan > 0 {
+				b.checkAge(ctx, s, ref, now(), opts.OlderThan)
+			}
+		case opts.OlderThan > 0:
+			b.Reason = "no pull request"
+			b.checkAge(ctx, s, ref, now(), opts.OlderThan)
+		default:
+			b.Reason = "no pull request"
+		}
+		if b.Action == ActionDelete && !opts.DryRun {
+			b.delete(ctx, s, open)
+		}
+		cleaned = append(cleaned, b)
+	}
+	return cleaned, nil
+}
+
+// checkAge adds the age of the last commit of ref to the reason of b, and
+// marks it for deletion if older than olderThan.
+func (b *CleanedBranch) checkAge(ctx context.Context, s Service, ref *scm.Reference, now time.Time, olderThan time.Duration) {
+	commit, err := s.FindCommit(ctx, b.Repo, ref.Sha)
+	if err != nil {
+		b.Action, b.Error = ActionFailed, fmt.Sprintf("failed to get the last commit: %s", err)
+		return
+	}
+	date := commit.Committer.Date
+	if date.IsZero() {
+		date = commit.Author.Date
+	}
+	age := now.Sub(date)
+	b.Reason += fmt.Sprintf(", last commit %s ago", FormatAge(age))
+	if age > olderThan {
+		b.Action = ActionDelete
+	}
+}
+
+// delete deletes the branch of b, closing its pull request first if open.
+func (b *CleanedBranch) delete(ctx context.Context, s Service, open bool) {
+	if open {
+		if err := s.ClosePullRequest(ctx, b.Repo, b.PullRequest); err != nil {
+			b.Action, b.Error = ActionFailed, fmt.Sprintf("failed to close pull request #%d: %s", b.PullRequest, err)
+			return
+		}
+	}
+	if err := s.DeleteBranch(ctx, b.Repo, b.Branch); err != nil {
+		b.Action, b.Error = ActionFailed, err.Error()
+		return
+	}
+	b.Action = ActionDeleted
+}
@@ -0,0 +1,187 @@
+package tracking
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ocraviotto/go-scm/scm"
+	"github.com/ocraviotto/yaml-updater/pkg/config"
+)
+
+var testNow = time.Date(2021, time.March, 30, 12, 0, 0, 0, time.UTC)
+
+func newCleanupService() *fakeService {
+	return &fakeService{
+		branches: []*scm.Reference{
+			{Name: "main", Sha: "s0"},
+			{Name: "gitops-aaaaa", Sha: "s1"},
+			{Name: "gitops-bbbbb", Sha: "s2"},
+			{Name: "gitops-ccccc", Sha: "s3"},
+			{Name: "gitops-ddddd", Sha: "s4"},
+			{Name: "gitops-eeeee", Sha: "s5"},
+			{Name: "gitops-fffff", Sha: "s6"},
+			{Name: "feature", Sha: "s7"},
+		},
+		prs: []*scm.PullRequest{
+			{Number: 1, Source: "gitops-aaaaa", Merged: true, Closed: true},
+			{Number: 2, Source: "gitops-bbbbb", Closed: true},
+			{Number: 3, Source: "gitops-ccccc"},
+			// A newer pull request for a reused branch is what counts.
+			{Number: 4, Source: "gitops-ddddd", Closed: true},
+			{Number: 5, Source: "gitops-ddddd"},
+		},
+		commits: map[string]*scm.Commit{
+			"s5": {Committer: scm.Signature{Date: testNow.Add(-40 * 24 * time.Hour)}},
+			"s6": {Author: scm.Signature{Date: testNow.Add(-3 * time.Hour)}},
+		},
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	s := newCleanupService()
+	target := Target{Keys: []string{"service-a"}, Repo: testRepo, Prefix: "gitops-", SourceBranches: []string{"main"}}
+
+	cleaned, err := Cleanup(context.Background(), s, target, CleanupOptions{OlderThan: 30 * 24 * time.Hour, Now: func() time.Time { return testNow }})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{"service-a"}
+	want := []CleanedBranch{
+		{Keys: keys, Repo: testRepo, Branch: "gitops-aaaaa", PullRequest: 1, Action: ActionDeleted, Reason: "pull request #1 is merged"},
+		{Keys: keys, Repo: testRepo, Branch: "gitops-bbbbb", PullRequest: 2, Action: ActionDeleted, Reason: "pull request #2 is closed"},
+		{Keys: keys, Repo: testRepo, Branch: "gitops-ccccc", PullRequest: 3, Action: ActionKeep, Reason: "pull request #3 is open"},
+		{Keys: keys, Repo: testRepo, Branch: "gitops-ddddd", PullRequest: 5, Action: ActionKeep, Reason: "pull request #5 is open"},
+		{Keys: keys, Repo: testRepo, Branch: "gitops-eeeee", Action: ActionDeleted, Reason: "no pull request, last commit 40d ago"},
+		{Keys: keys, Repo: testRepo, Branch: "gitops-fffff", Action: ActionKeep, Reason: "no pull request, last commit 3h ago"},
+	}
+	if diff := cmp.Diff(want, cleaned); diff != "" {
+		t.Errorf("Cleanup() failed diff\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"gitops-aaaaa", "gitops-bbbbb", "gitops-eeeee"}, s.deleted); diff != "" {
+		t.Errorf("deleted branches failed diff\n%s", diff)
+	}
+}
+
+func TestCleanupDryRun(t *testing.T) {
+	s := newCleanupService()
+	target := Target{Keys: []string{"service-a"}, Repo: testRepo, Prefix: "gitops-", SourceBranches: []string{"main"}}
+
+	cleaned, err := Cleanup(context.Background(), s, target, CleanupOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actions []string
+	for _, b := range cleaned {
+		actions = append(actions, b.Branch+" "+b.Action+": "+b.Reason)
+	}
+	want := []string{
+		"gitops-aaaaa delete: pull request #1 is merged",
+		"gitops-bbbbb delete: pull request #2 is closed",
+		"gitops-ccccc keep: pull request #3 is open",
+		"gitops-ddddd keep: pull request #5 is open",
+		"gitops-eeeee keep: no pull request",
+		"gitops-fffff keep: no pull request",
+	}
+	if diff := cmp.Diff(want, actions); diff != "" {
+		t.Errorf("Cleanup() failed diff\n%s", diff)
+	}
+	if len(s.deleted) > 0 {
+		t.Errorf("dry run deleted %v", s.deleted)
+	}
+}
+
+func TestCleanupCloseStale(t *testing.T) {
+	s := newCleanupService()
+	s.commits["s3"] = &scm.Commit{Committer: scm.Signature{Date: testNow.Add(-40 * 24 * time.Hour)}}
+	s.commits["s4"] = &scm.Commit{Committer: scm.Signature{Date: testNow.Add(-3 * time.Hour)}}
+	target := Target{Keys: []string{"service-a"}, Repo: testRepo, Prefix: "gitops-", SourceBranches: []string{"main"}}
+
+	cleaned, err := Cleanup(context.Background(), s, target, CleanupOptions{OlderThan: 30 * 24 * time.Hour, CloseStale: true, Now: func() time.Time { return testNow }})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{"service-a"}
+	want := []CleanedBranch{
+		{Keys: keys, Repo: testRepo, Branch: "gitops-ccccc", PullRequest: 3, Action: ActionDeleted, Reason: "pull request #3 is open, last commit 40d ago"},
+		{Keys: keys, Repo: testRepo, Branch: "gitops-ddddd", PullRequest: 5, Action: ActionKeep, Reason: "pull request #5 is open, last commit 3h ago"},
+	}
+	if diff := cmp.Diff(want, cleaned[2:4]); diff != "" {
+		t.Errorf("Cleanup() failed diff\n%s", diff)
+	}
+	if diff := cmp.Diff([]int{3}, s.closed); diff != "" {
+		t.Errorf("closed pull requests failed diff\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"gitops-aaaaa", "gitops-bbbbb", "gitops-ccccc", "gitops-eeeee"}, s.deleted); diff != "" {
+		t.Errorf("deleted branches failed diff\n%s", diff)
+	}
+}
+
+func TestCleanupFailures(t *testing.T) {
+	s := newCleanupService()
+	s.deleteErr = errors.New("forbidden")
+	s.commits = nil
+	target := Target{Keys: []string{"service-a"}, Repo: testRepo, Prefix: "gitops-a"}
+	older := Target{Keys: []string{"service-a"}, Repo: testRepo, Prefix: "gitops-e"}
+
+	cleaned, err := Cleanup(context.Background(), s, target, CleanupOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []CleanedBranch{
+		{Keys: []string{"service-a"}, Repo: testRepo, Branch: "gitops-aaaaa", PullRequest: 1, Action: ActionFailed, Reason: "pull request #1 is merged", Error: "forbidden"},
+	}
+	if diff := cmp.Diff(want, cleaned); diff != "" {
+		t.Errorf("Cleanup() failed diff\n%s", diff)
+	}
+
+	cleaned, err = Cleanup(context.Background(), s, older, CleanupOptions{OlderThan: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []CleanedBranch{
+		{Keys: []string{"service-a"}, Repo: testRepo, Branch: "gitops-eeeee", Action: ActionFailed, Reason: "no pull request", Error: "failed to get the last commit: not found"},
+	}
+	if diff := cmp.Diff(want, cleaned); diff != "" {
+		t.Errorf("Cleanup() failed diff\n%s", diff)
+	}
+}
+
+func TestCleanupWithoutPrefix(t *testing.T) {
+	s := newCleanupService()
+
+	cleaned, err := Cleanup(context.Background(), s, Target{Keys: []string{"service-a"}, Repo: testRepo}, CleanupOptions{OlderThan: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cleaned) > 0 || len(s.deleted) > 0 {
+		t.Errorf("got %v and deleted %v without a prefix", cleaned, s.deleted)
+	}
+}
+
+func TestCleanupKeepsSourceBranches(t *testing.T) {
+	s := newCleanupService()
+	s.branches = append(s.branches, &scm.Reference{Name: "gitops-live", Sha: "s5"})
+	configs := &config.RepoConfiguration{
+		Repositories: map[string]*config.Repository{
+			"service-a": {SourceRepo: testRepo, SourceBranch: "main", BranchGenerateName: "gitops-"},
+			"direct":    {SourceRepo: testRepo, SourceBranch: "gitops-live", DisablePRCreation: true},
+		},
+	}
+
+	for _, target := range Targets(configs) {
+		if _, err := Cleanup(context.Background(), s, target, CleanupOptions{OlderThan: 30 * 24 * time.Hour, Now: func() time.Time { return testNow }}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if diff := cmp.Diff([]string{"gitops-aaaaa", "gitops-bbbbb", "gitops-eeeee"}, s.deleted); diff != "" {
+		t.Errorf("deleted branches failed diff\n%s", diff)
+	}
+}
@@ -0,0 +1,227 @@
+package tracking
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ocraviotto/go-scm/scm"
+)
+
+// pageSize is the number of items requested in each page of a list.
+const pageSize = 100
+
+// SCMService implements Service with a go-scm client.
+type SCMService struct {
+	client *scm.Client
+}
+
+// New creates and returns a SCMService using c.
+func New(c *scm.Client) *SCMService {
+	return &SCMService{client: c}
+}
+
+// ListBranches implements Service, fetching every page.
+func (s *SCMService) ListBranches(ctx context.Context, repo string) ([]*scm.Reference, error) {
+	var all []*scm.Reference
+	opts := scm.ListOptions{Page: 1, Size: pageSize}
+	for {
+		refs, res, err := s.client.Git.ListBranches(ctx, repo, opts)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, refs...)
+		if !hasNextPage(res, opts.Page) {
+			return all, nil
+		}
+		opts.Page = res.Page.Next
+	}
+}
+
+// ListPullRequests implements Service, fetching every page.
+func (s *SCMService) ListPullRequests(ctx context.Context, repo string) ([]*scm.PullRequest, error) {
+	var all []*scm.PullRequest
+	opts := scm.PullRequestListOptions{Page: 1, Size: pageSize, Open: true, Closed: true}
+	for {
+		prs, res, err := s.client.PullRequests.List(ctx, repo, opts)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, prs...)
+		if !hasNextPage(res, opts.Page) {
+			return all, nil
+		}
+		opts.Page = res.Page.Next
+	}
+}
+
+// FindOpenPullRequest returns the open pull request from branch in repo, or
+// nil if there is none, fetching the pages of open pull requests until found.
+func (s *SCMService) FindOpenPullRequest(ctx context.Context, repo, branch string) (*scm.PullRequest, error) {
+	opts := scm.PullRequestListOptions{Page: 1, Size: pageSize, Open: true}
+	for {
+		prs, res, err := s.client.PullRequests.List(ctx, repo, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, pr := range prs {
+			if pr.Source == branch && State(pr) == "open" {
+				return pr, nil
+			}
+		}
+		if !hasNextPage(res, opts.Page) {
+			return nil, nil
+		}
+		opts.Page = res.Page.Next
+	}
+}
+
+// FindCommit implements Service.
+func (s *SCMService) FindCommit(ctx context.Context, repo, ref string) (*scm.Commit, error) {
+	c, _, err := s.client.Git.FindCommit(ctx, repo, ref)
+	return c, err
+}
+
+// ListStatuses implements Service, fetching every page.
+func (s *SCMService) ListStatuses(ctx context.Context, repo, ref string) ([]*scm.Status, error) {
+	var all []*scm.Status
+	opts := scm.ListOptions{Page: 1, Size: pageSize}
+	for {
+		statuses, res, err := s.client.Repositories.ListStatus(ctx, repo, ref, opts)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, statuses...)
+		if !hasNextPage(res, opts.Page) {
+			return all, nil
+		}
+		opts.Page = res.Page.Next
+	}
+}
+
+// ListCheckRuns implements Service with the checks API of GitHub, fetching
+// every page, as go-scm has no call for it. Other drivers have no check runs,
+// and return none.
+func (s *SCMService) ListCheckRuns(ctx context.Context, repo, ref string) ([]*scm.Status, error) {
+	if s.client.Driver != scm.DriverGithub {
+		return nil, nil
+	}
+	var all []*scm.Status
+	for page := 1; ; page++ {
+		req := &scm.Request{
+			Method: http.MethodGet,
+			Path:   fmt.Sprintf("repos/%s/commits/%s/check-runs?per_page=%d&page=%d", repo, url.PathEscape(ref), pageSize, page),
+		}
+		res, err := s.client.Do(ctx, req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the check runs of %s: %w", ref, err)
+		}
+		var out struct {
+			TotalCount int `json:"total_count"`
+			CheckRuns  []struct {
+				Name       string `json:"name"`
+				Status     string `json:"status"`
+				Conclusion string `json:"conclusion"`
+			} `json:"check_runs"`
+		}
+		err = decodeResponse(res, &out)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the check runs of %s: %w", ref, err)
+		}
+		for _, r := range out.CheckRuns {
+			all = append(all, &scm.Status{Label: r.Name, State: checkRunState(r.Status, r.Conclusion)})
+		}
+		if len(out.CheckRuns) == 0 || len(all) >= out.TotalCount {
+			return all, nil
+		}
+	}
+}
+
+// decodeResponse decodes the JSON body of res into v, and closes it.
+func decodeResponse(res *scm.Response, v interface{}) error {
+	defer res.Body.Close()
+	if res.Status >= http.StatusMultipleChoices {
+		msg, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("%d %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// checkRunState returns the state of a commit status matching the status and
+// conclusion of a check run.
+func checkRunState(status, conclusion string) scm.State {
+	if status != "completed" {
+		return scm.StatePending
+	}
+	switch conclusion {
+	case "success", "neutral", "skipped":
+		return scm.StateSuccess
+	case "cancelled":
+		return scm.StateCanceled
+	case "failure", "timed_out", "action_required", "startup_failure":
+		return scm.StateFailure
+	}
+	return scm.StatePending
+}
+
+// ClosePullRequest implements Service.
+func (s *SCMService) ClosePullRequest(ctx context.Context, repo string, number int) error {
+	_, err := s.client.PullRequests.Close(ctx, repo, number)
+	return err
+}
+
+// DeleteBranch implements Service with the API of each driver, as go-scm
+// has no call for it.
+func (s *SCMService) DeleteBranch(ctx context.Context, repo, branch string) error {
+	req := &scm.Request{Method: http.MethodDelete}
+	switch s.client.Driver {
+	case scm.DriverGithub:
+		req.Path = fmt.Sprintf("repos/%s/git/refs/heads/%s", repo, escapePath(branch))
+	case scm.DriverGitlab:
+		req.Path = fmt.Sprintf("api/v4/projects/%s/repository/branches/%s", url.PathEscape(repo), url.PathEscape(branch))
+	case scm.DriverGitea:
+		req.Path = fmt.Sprintf("api/v1/repos/%s/branches/%s", repo, escapePath(branch))
+	case scm.DriverBitbucket:
+		req.Path = fmt.Sprintf("2.0/repositories/%s/refs/branches/%s", repo, escapePath(branch))
+	case scm.DriverStash:
+		project, name := scm.Split(repo)
+		body, err := json.Marshal(map[string]interface{}{"name": "refs/heads/" + branch, "dryRun": false})
+		if err != nil {
+			return err
+		}
+		req.Path = fmt.Sprintf("rest/branch-utils/1.0/projects/%s/repos/%s/branches", project, name)
+		req.Header = http.Header{"Content-Type": []string{"application/json"}}
+		req.Body = bytes.NewReader(body)
+	default:
+		return fmt.Errorf("deleting branches is not supported with the %s driver", s.client.Driver)
+	}
+	res, err := s.client.Do(ctx, req)
+	if err != nil {
+		return fmt.Errorf("failed to delete branch %s: %w", branch, err)
+	}
+	defer res.Body.Close()
+	if res.Status >= http.StatusMultipleChoices {
+		msg, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("failed to delete branch %s: %d %s", branch, res.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// hasNextPage returns true if res points to a page after page.
+func hasNextPage(res *scm.Response, page int) bool {
+	return res != nil && res.Page.Next > page
+}
+
+// escapePath escapes each segment of a path with slashes, e.g. a branch name.
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,207 @@
+package tracking
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ocraviotto/go-scm/scm"
+	"github.com/ocraviotto/go-scm/scm/factory"
+	"github.com/ocraviotto/yaml-updater/test"
+)
+
+func TestDeleteBranch(t *testing.T) {
+	deleteTests := []struct {
+		driver   string
+		wantPath string
+		wantBody string
+	}{
+		{"github", "/api/v3/repos/my-org/my-project/git/refs/heads/gitops/service-a", ""},
+		{"gitlab", "/api/v4/projects/my-org%2Fmy-project/repository/branches/gitops%2Fservice-a", ""},
+		{"gitea", "/api/v1/repos/my-org/my-project/branches/gitops/service-a", ""},
+		{"bitbucketcloud", "/2.0/repositories/my-org/my-project/refs/branches/gitops/service-a", ""},
+		{"bitbucketserver", "/rest/branch-utils/1.0/projects/my-org/repos/my-project/branches", `{"dryRun":false,"name":"refs/heads/gitops/service-a"}`},
+	}
+
+	for _, tt := range deleteTests {
+		t.Run(tt.driver, func(rt *testing.T) {
+			var gotPath, gotBody string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodDelete {
+					rt.Errorf("got method %s, want DELETE", r.Method)
+				}
+				gotPath = r.URL.EscapedPath()
+				b, _ := ioutil.ReadAll(r.Body)
+				gotBody = string(b)
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer ts.Close()
+			c, err := factory.NewClient(tt.driver, ts.URL, "")
+			if err != nil {
+				rt.Fatal(err)
+			}
+
+			if err := New(c).DeleteBranch(context.Background(), testRepo, "gitops/service-a"); err != nil {
+				rt.Fatal(err)
+			}
+
+			if gotPath != tt.wantPath {
+				rt.Errorf("got path %s, want %s", gotPath, tt.wantPath)
+			}
+			if gotBody != tt.wantBody {
+				rt.Errorf("got body %s, want %s", gotBody, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestDeleteBranchErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message": "Reference does not exist"}`, http.StatusUnprocessableEntity)
+	}))
+	defer ts.Close()
+	c, err := factory.NewClient("github", ts.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = New(c).DeleteBranch(context.Background(), testRepo, "gitops-aaaaa")
+	if !test.MatchError(t, `failed to delete branch gitops-aaaaa: 422 {"message": "Reference does not exist"}`, err) {
+		t.Fatalf("got error %v", err)
+	}
+
+	c, err = factory.NewClient("gogs", ts.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = New(c).DeleteBranch(context.Background(), testRepo, "gitops-aaaaa")
+	if !test.MatchError(t, "deleting branches is not supported with the gogs driver", err) {
+		t.Fatalf("got error %v", err)
+	}
+}
+
+func TestListBranchesPages(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("page")
+		if page == "1" {
+			w.Header().Set("Link", fmt.Sprintf(`<%s/api/v3/repos/my-org/my-project/branches?page=2&per_page=100>; rel="next"`, ts.URL))
+		}
+		fmt.Fprintf(w, `[{"name": "gitops-%s", "commit": {"sha": "s%s"}}]`, page, page)
+	}))
+	defer ts.Close()
+	c, err := factory.NewClient("github", ts.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refs, err := New(c).ListBranches(context.Background(), testRepo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, ref := range refs {
+		got = append(got, ref.Name+" "+ref.Sha)
+	}
+	if diff := cmp.Diff([]string{"gitops-1 s1", "gitops-2 s2"}, got); diff != "" {
+		t.Errorf("ListBranches() failed diff\n%s", diff)
+	}
+}
+
+func TestFindOpenPullRequest(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if state := r.URL.Query().Get("state"); state != "" {
+			t.Errorf("got state %s, want open pull requests only", state)
+		}
+		page := r.URL.Query().Get("page")
+		if page == "1" {
+			w.Header().Set("Link", fmt.Sprintf(`<%s/api/v3/repos/my-org/my-project/pulls?page=2&per_page=100>; rel="next"`, ts.URL))
+		}
+		fmt.Fprintf(w, `[{"number": %s, "state": "open", "head": {"ref": "gitops-%s"}}]`, page, page)
+	}))
+	defer ts.Close()
+	c, err := factory.NewClient("github", ts.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pr, err := New(c).FindOpenPullRequest(context.Background(), testRepo, "gitops-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pr == nil || pr.Number != 2 {
+		t.Fatalf("got pull request %v, want number 2", pr)
+	}
+
+	pr, err = New(c).FindOpenPullRequest(context.Background(), testRepo, "gitops-3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pr != nil {
+		t.Fatalf("got pull request %v, want none", pr)
+	}
+}
+
+func TestListCheckRuns(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/repos/my-org/my-project/commits/s1/check-runs" {
+			t.Errorf("got path %s", r.URL.Path)
+		}
+		switch r.URL.Query().Get("page") {
+		case "1":
+			fmt.Fprint(w, `{"total_count": 3, "check_runs": [{"name": "build", "status": "completed", "conclusion": "success"}, {"name": "test", "status": "in_progress"}]}`)
+		default:
+			fmt.Fprint(w, `{"total_count": 3, "check_runs": [{"name": "lint", "status": "completed", "conclusion": "timed_out"}]}`)
+		}
+	}))
+	defer ts.Close()
+	c, err := factory.NewClient("github", ts.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runs, err := New(c).ListCheckRuns(context.Background(), testRepo, "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*scm.Status{
+		{Label: "build", State: scm.StateSuccess},
+		{Label: "test", State: scm.StatePending},
+		{Label: "lint", State: scm.StateFailure},
+	}
+	if diff := cmp.Diff(want, runs); diff != "" {
+		t.Errorf("ListCheckRuns() failed diff\n%s", diff)
+	}
+}
+
+func TestListCheckRunsErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message": "Resource not accessible by integration"}`, http.StatusForbidden)
+	}))
+	defer ts.Close()
+	c, err := factory.NewClient("github", ts.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = New(c).ListCheckRuns(context.Background(), testRepo, "s1")
+	if !test.MatchError(t, `failed to list the check runs of s1: 403 {"message": "Resource not accessible by integration"}`, err) {
+		t.Fatalf("got error %v", err)
+	}
+
+	c, err = factory.NewClient("gitlab", ts.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs, err := New(c).ListCheckRuns(context.Background(), testRepo, "s1")
+	if err != nil || runs != nil {
+		t.Fatalf("got check runs %v and error %v, want none with gitlab", runs, err)
+	}
+}
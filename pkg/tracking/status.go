@@ -0,0 +1,155 @@
+package tracking
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ocraviotto/go-scm/scm"
+)
+
+// Check states, the combined state of the commit statuses and check runs of a
+// PR.
+const (
+	ChecksNone    = "none"
+	ChecksPending = "pending"
+	ChecksSuccess = "success"
+	ChecksFailure = "failure"
+)
+
+// StatusOptions configures PullRequests.
+type StatusOptions struct {
+	// State keeps the PRs in this state, open, merged or closed, or all of
+	// them if empty.
+	State string
+	// Since keeps the PRs created within this time, or all of them if 0.
+	Since time.Duration
+	// Now returns the current time, time.Now if nil.
+	Now func() time.Time
+}
+
+// PullRequestStatus is the state of a PR created by a previous run. Only Keys,
+// Repo and Error are set when the PRs of the repository can not be listed.
+type PullRequestStatus struct {
+	Keys    []string   `json:"keys"`
+	Repo    string     `json:"sourceRepo"`
+	Number  int        `json:"number,omitempty"`
+	Title   string     `json:"title,omitempty"`
+	Branch  string     `json:"branch,omitempty"`
+	Link    string     `json:"link,omitempty"`
+	State   string     `json:"state,omitempty"`
+	Checks  string     `json:"checks,omitempty"`
+	Created *time.Time `json:"created,omitempty"`
+	Age     string     `json:"age,omitempty"`
+	Error   string     `json:"error,omitempty"`
+}
+
+// PullRequests returns the PRs of t, the latest first: those whose body marks
+// one of its keys, and those without a marker from a branch with its prefix.
+// The checks are only given for open PRs, as the combined state of the
+// latest commit status of each context and the latest check run of each
+// check.
+func PullRequests(ctx context.Context, s Service, t Target, opts StatusOptions) ([]PullRequestStatus, error) {
+	now := time.Now
+	if opts.Now != nil {
+		now = opts.Now
+	}
+	prs, err := s.ListPullRequests(ctx, t.Repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the pull requests of %s: %w", t.Repo, err)
+	}
+	sortLatestFirst(prs)
+	var statuses []PullRequestStatus
+	for _, pr := range prs {
+		keys, ok := t.owns(pr)
+		if !ok || (opts.State != "" && State(pr) != opts.State) {
+			continue
+		}
+		created := pr.Created
+		age := now().Sub(created)
+		if opts.Since > 0 && age > opts.Since {
+			continue
+		}
+		status := PullRequestStatus{
+			Keys:    keys,
+			Repo:    t.Repo,
+			Number:  pr.Number,
+			Title:   pr.Title,
+			Branch:  pr.Source,
+			Link:    pr.Link,
+			State:   State(pr),
+			Created: &created,
+			Age:     FormatAge(age),
+		}
+		if status.State == "open" {
+			checks, err := prChecks(ctx, s, t.Repo, pr.Sha)
+			if err != nil {
+				status.Error = fmt.Sprintf("failed to get the checks: %s", err)
+			} else {
+				status.Checks = checks
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// owns returns the keys of t that pr was created for, and whether it was
+// created for t at all.
+func (t Target) owns(pr *scm.PullRequest) ([]string, bool) {
+	if key, ok := MarkedKey(pr.Body); ok {
+		return []string{key}, contains(t.Keys, key)
+	}
+	return t.Keys, t.Prefix != "" && strings.HasPrefix(pr.Source, t.Prefix) && !contains(t.SourceBranches, pr.Source)
+}
+
+// prChecks returns the combined state of the commit statuses and the check
+// runs of ref. They are combined apart, as a check run and a commit status
+// can share a name.
+func prChecks(ctx context.Context, s Service, repo, ref string) (string, error) {
+	statuses, err := s.ListStatuses(ctx, repo, ref)
+	if err != nil {
+		return "", err
+	}
+	runs, err := s.ListCheckRuns(ctx, repo, ref)
+	if err != nil {
+		return "", err
+	}
+	return worstChecks(combineChecks(statuses), combineChecks(runs)), nil
+}
+
+// checksRank orders the check states, from the least to the most relevant.
+var checksRank = map[string]int{ChecksNone: 0, ChecksSuccess: 1, ChecksPending: 2, ChecksFailure: 3}
+
+// worstChecks returns the most relevant of the check states a and b.
+func worstChecks(a, b string) string {
+	if checksRank[b] > checksRank[a] {
+		return b
+	}
+	return a
+}
+
+// combineChecks returns the combined state of statuses, taking only the
+// first, the latest, of each context.
+func combineChecks(statuses []*scm.Status) string {
+	if len(statuses) == 0 {
+		return ChecksNone
+	}
+	seen := map[string]bool{}
+	combined := ChecksSuccess
+	for _, s := range statuses {
+		if seen[s.Label] {
+			continue
+		}
+		seen[s.Label] = true
+		switch s.State {
+		case scm.StateSuccess:
+		case scm.StateFailure, scm.StateError, scm.StateCanceled:
+			return ChecksFailure
+		default:
+			combined = ChecksPending
+		}
+	}
+	return combined
+}
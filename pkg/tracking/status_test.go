@@ -0,0 +1,142 @@
+package tracking
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ocraviotto/go-scm/scm"
+)
+
+func newStatusService() *fakeService {
+	created := func(d time.Duration) time.Time { return testNow.Add(-d) }
+	return &fakeService{
+		prs: []*scm.PullRequest{
+			{Number: 1, Title: "Old", Source: "gitops-aaaaa", Sha: "s1", Merged: true, Closed: true, Created: created(40 * 24 * time.Hour)},
+			{Number: 2, Title: "Update a", Source: "gitops-bbbbb", Sha: "s2", Body: "Automated update\n\n" + Marker("service-a"), Created: created(3 * time.Hour)},
+			{Number: 3, Title: "Update b", Source: "gitops-ccccc", Sha: "s3", Body: Marker("service-b"), Created: created(2 * time.Hour)},
+			{Number: 4, Title: "Update other", Source: "gitops-ddddd", Sha: "s4", Body: Marker("other"), Created: created(time.Hour)},
+			{Number: 5, Title: "Feature", Source: "feature", Sha: "s5", Created: created(time.Hour)},
+			{Number: 6, Title: "Update a", Source: "gitops-eeeee", Sha: "s6", Body: Marker("service-a"), Closed: true, Created: created(30 * time.Minute)},
+		},
+		statuses: map[string][]*scm.Status{
+			"s2": {
+				{Label: "ci/build", State: scm.StateSuccess},
+				{Label: "ci/test", State: scm.StateFailure},
+				{Label: "ci/test", State: scm.StatePending},
+			},
+			"s3": {
+				{Label: "ci/build", State: scm.StateSuccess},
+			},
+		},
+		checkRuns: map[string][]*scm.Status{
+			"s3": {
+				{Label: "ci/build", State: scm.StateSuccess},
+				{Label: "ci/test", State: scm.StatePending},
+			},
+		},
+	}
+}
+
+// ago returns the time d before testNow.
+func ago(d time.Duration) *time.Time {
+	t := testNow.Add(-d)
+	return &t
+}
+
+func TestPullRequests(t *testing.T) {
+	s := newStatusService()
+	target := Target{Keys: []string{"service-a", "service-b"}, Repo: testRepo, Prefix: "gitops-", SourceBranches: []string{"main"}}
+
+	statuses, err := PullRequests(context.Background(), s, target, StatusOptions{Now: func() time.Time { return testNow }})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []PullRequestStatus{
+		{Keys: []string{"service-a"}, Repo: testRepo, Number: 6, Title: "Update a", Branch: "gitops-eeeee", State: "closed", Created: ago(30 * time.Minute), Age: "30m"},
+		{Keys: []string{"service-b"}, Repo: testRepo, Number: 3, Title: "Update b", Branch: "gitops-ccccc", State: "open", Checks: ChecksPending, Created: ago(2 * time.Hour), Age: "2h"},
+		{Keys: []string{"service-a"}, Repo: testRepo, Number: 2, Title: "Update a", Branch: "gitops-bbbbb", State: "open", Checks: ChecksFailure, Created: ago(3 * time.Hour), Age: "3h"},
+		{Keys: []string{"service-a", "service-b"}, Repo: testRepo, Number: 1, Title: "Old", Branch: "gitops-aaaaa", State: "merged", Created: ago(40 * 24 * time.Hour), Age: "40d"},
+	}
+	if diff := cmp.Diff(want, statuses); diff != "" {
+		t.Errorf("PullRequests() failed diff\n%s", diff)
+	}
+}
+
+func TestPullRequestsFilters(t *testing.T) {
+	target := Target{Keys: []string{"service-a", "service-b"}, Repo: testRepo, Prefix: "gitops-"}
+	filterTests := []struct {
+		name string
+		opts StatusOptions
+		want []int
+	}{
+		{"open", StatusOptions{State: "open"}, []int{3, 2}},
+		{"merged", StatusOptions{State: "merged"}, []int{1}},
+		{"since", StatusOptions{Since: 150 * time.Minute}, []int{6, 3}},
+		{"open since", StatusOptions{State: "open", Since: 150 * time.Minute}, []int{3}},
+	}
+
+	for _, tt := range filterTests {
+		t.Run(tt.name, func(rt *testing.T) {
+			tt.opts.Now = func() time.Time { return testNow }
+			statuses, err := PullRequests(context.Background(), newStatusService(), target, tt.opts)
+			if err != nil {
+				rt.Fatal(err)
+			}
+			var got []int
+			for _, s := range statuses {
+				got = append(got, s.Number)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				rt.Errorf("PullRequests() failed diff\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPullRequestsWithoutPrefix(t *testing.T) {
+	target := Target{Keys: []string{"service-a"}, Repo: testRepo}
+
+	statuses, err := PullRequests(context.Background(), newStatusService(), target, StatusOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []int
+	for _, s := range statuses {
+		got = append(got, s.Number)
+	}
+	if diff := cmp.Diff([]int{6, 2}, got); diff != "" {
+		t.Errorf("PullRequests() failed diff\n%s", diff)
+	}
+}
+
+func TestCombineChecks(t *testing.T) {
+	checkTests := []struct {
+		statuses []*scm.Status
+		want     string
+	}{
+		{nil, ChecksNone},
+		{[]*scm.Status{{Label: "ci", State: scm.StateSuccess}}, ChecksSuccess},
+		{[]*scm.Status{{Label: "ci", State: scm.StateSuccess}, {Label: "ci", State: scm.StateFailure}}, ChecksSuccess},
+		{[]*scm.Status{{Label: "ci", State: scm.StateSuccess}, {Label: "lint", State: scm.StateError}}, ChecksFailure},
+		{[]*scm.Status{{Label: "ci", State: scm.StatePending}, {Label: "lint", State: scm.StateSuccess}}, ChecksPending},
+	}
+
+	for _, tt := range checkTests {
+		if got := combineChecks(tt.statuses); got != tt.want {
+			t.Errorf("combineChecks(%v) got %q, want %q", tt.statuses, got, tt.want)
+		}
+	}
+}
+
+func TestMarkedKey(t *testing.T) {
+	if key, ok := MarkedKey("Automated update\n\n" + Marker("service-a")); !ok || key != "service-a" {
+		t.Errorf("MarkedKey() got %q, %v, want service-a", key, ok)
+	}
+	if key, ok := MarkedKey("Automated update from \"my-image\""); ok {
+		t.Errorf("MarkedKey() got %q without a marker", key)
+	}
+}
@@ -0,0 +1,196 @@
+// Package tracking finds the branches and pull requests created by previous
+// runs, to clean them up or report on them.
+package tracking
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ocraviotto/go-scm/scm"
+	"github.com/ocraviotto/yaml-updater/pkg/config"
+)
+
+// Service is what tracking needs from a Git service.
+type Service interface {
+	ListBranches(ctx context.Context, repo string) ([]*scm.Reference, error)
+	// ListPullRequests returns the open and closed pull requests of repo.
+	ListPullRequests(ctx context.Context, repo string) ([]*scm.PullRequest, error)
+	FindCommit(ctx context.Context, repo, ref string) (*scm.Commit, error)
+	DeleteBranch(ctx context.Context, repo, branch string) error
+	ClosePullRequest(ctx context.Context, repo string, number int) error
+	// ListStatuses returns the commit statuses of ref, the latest first.
+	ListStatuses(ctx context.Context, repo, ref string) ([]*scm.Status, error)
+	// ListCheckRuns returns the latest check run of each check of ref, as
+	// statuses labelled with the name of the check.
+	ListCheckRuns(ctx context.Context, repo, ref string) ([]*scm.Status, error)
+}
+
+// Target is a repository whose PR branches share a prefix, for one or more
+// repository configurations.
+type Target struct {
+	// Keys are the keys of the repository configurations.
+	Keys       []string
+	Connection string
+	Repo       string
+	Prefix     string
+	// SourceBranches are never taken for PR branches, even if they have the
+	// prefix.
+	SourceBranches []string
+}
+
+// Targets returns the targets of the repositories in configs, sorted by
+// repository, prefix and connection, leaving out those that create no PRs.
+// The SourceBranches of each target are those of every repository in configs
+// for the same sourceRepo, including those that commit straight to it.
+func Targets(configs *config.RepoConfiguration) []Target {
+	byID := map[string]*Target{}
+	var ids []string
+	for _, key := range configs.Keys() {
+		r := configs.Repositories[key]
+		prefix := BranchPrefix(r)
+		if r.DisablePRCreation {
+			continue
+		}
+		id := strings.Join([]string{r.SourceRepo, prefix, r.Connection}, "\x00")
+		t, ok := byID[id]
+		if !ok {
+			t = &Target{Connection: r.Connection, Repo: r.SourceRepo, Prefix: prefix}
+			byID[id] = t
+			ids = append(ids, id)
+		}
+		t.Keys = append(t.Keys, key)
+	}
+	sort.Strings(ids)
+	targets := make([]Target, 0, len(ids))
+	for _, id := range ids {
+		targets = append(targets, *byID[id])
+	}
+	return ProtectSourceBranches(targets, configs)
+}
+
+// ProtectSourceBranches adds the sourceBranch of every repository in configs
+// to the SourceBranches of the targets for the same sourceRepo, whatever its
+// PR setting, e.g. with configs before --only or --selector were applied.
+func ProtectSourceBranches(targets []Target, configs *config.RepoConfiguration) []Target {
+	for i := range targets {
+		t := &targets[i]
+		for _, key := range configs.Keys() {
+			r := configs.Repositories[key]
+			if r != nil && r.SourceRepo == t.Repo && r.SourceBranch != "" && !contains(t.SourceBranches, r.SourceBranch) {
+				t.SourceBranches = append(t.SourceBranches, r.SourceBranch)
+			}
+		}
+		sort.Strings(t.SourceBranches)
+	}
+	return targets
+}
+
+// BranchPrefix returns the prefix of the names of the branches created for
+// the PRs of r, the text before the first action of its branchTemplate, if
+// any, or else its branchGenerateName.
+func BranchPrefix(r *config.Repository) string {
+	if r.BranchTemplate != "" && (r.BranchNaming == "" || r.BranchNaming == "template") {
+		if i := strings.Index(r.BranchTemplate, "{{"); i >= 0 {
+			return r.BranchTemplate[:i]
+		}
+		return r.BranchTemplate
+	}
+	return r.BranchGenerateName
+}
+
+// branches returns the branches of t, sorted by name. Without a prefix, it
+// returns none, as every branch would match.
+func (t Target) branches(ctx context.Context, s Service) ([]*scm.Reference, error) {
+	if t.Prefix == "" {
+		return nil, nil
+	}
+	refs, err := s.ListBranches(ctx, t.Repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the branches of %s: %w", t.Repo, err)
+	}
+	var branches []*scm.Reference
+	for _, ref := range refs {
+		if strings.HasPrefix(ref.Name, t.Prefix) && !contains(t.SourceBranches, ref.Name) {
+			branches = append(branches, ref)
+		}
+	}
+	sort.Slice(branches, func(i, j int) bool { return branches[i].Name < branches[j].Name })
+	return branches, nil
+}
+
+// pullRequestsBySource returns the pull requests of repo by source branch,
+// the latest first.
+func pullRequestsBySource(ctx context.Context, s Service, repo string) (map[string][]*scm.PullRequest, error) {
+	prs, err := s.ListPullRequests(ctx, repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the pull requests of %s: %w", repo, err)
+	}
+	sortLatestFirst(prs)
+	bySource := map[string][]*scm.PullRequest{}
+	for _, pr := range prs {
+		bySource[pr.Source] = append(bySource[pr.Source], pr)
+	}
+	return bySource, nil
+}
+
+// sortLatestFirst sorts prs by number, the latest first.
+func sortLatestFirst(prs []*scm.PullRequest) {
+	sort.Slice(prs, func(i, j int) bool { return prs[i].Number > prs[j].Number })
+}
+
+// State returns the state of pr: open, merged or closed.
+func State(pr *scm.PullRequest) string {
+	switch {
+	case pr.Merged:
+		return "merged"
+	case pr.Closed:
+		return "closed"
+	}
+	return "open"
+}
+
+// FormatAge returns d rounded to days, hours or minutes, e.g. 3d.
+func FormatAge(d time.Duration) string {
+	switch {
+	case d >= 48*time.Hour:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	case d >= 2*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dm", int(d.Minutes()))
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// markerPrefix starts the marker of the repository key in the body of a PR.
+const markerPrefix = "<!-- yaml-updater:key="
+
+// Marker returns the marker of the repository key in the body of its PRs,
+// an HTML comment that Git services do not show.
+func Marker(key string) string {
+	return markerPrefix + key + " -->"
+}
+
+// MarkedKey returns the repository key marked in the body of a PR, if any.
+func MarkedKey(body string) (string, bool) {
+	i := strings.Index(body, markerPrefix)
+	if i < 0 {
+		return "", false
+	}
+	rest := body[i+len(markerPrefix):]
+	end := strings.Index(rest, " -->")
+	if end < 0 {
+		return "", false
+	}
+	return rest[:end], true
+}
@@ -0,0 +1,127 @@
+package tracking
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ocraviotto/go-scm/scm"
+	"github.com/ocraviotto/yaml-updater/pkg/config"
+)
+
+const testRepo = "my-org/my-project"
+
+func TestTargets(t *testing.T) {
+	configs := &config.RepoConfiguration{
+		Repositories: map[string]*config.Repository{
+			"service-a":  {SourceRepo: testRepo, SourceBranch: "main", BranchGenerateName: "gitops-"},
+			"service-b":  {SourceRepo: testRepo, SourceBranch: "release", BranchGenerateName: "gitops-"},
+			"service-c":  {SourceRepo: testRepo, SourceBranch: "main", BranchTemplate: "gitops/{{.Key}}/{{.Value | slug}}"},
+			"internal":   {Connection: "internal", SourceRepo: testRepo, SourceBranch: "main", BranchGenerateName: "gitops-"},
+			"direct":     {SourceRepo: testRepo, SourceBranch: "gitops-live", BranchGenerateName: "gitops-", DisablePRCreation: true},
+			"other":      {SourceRepo: "my-org/other", SourceBranch: "gitops-other", DisablePRCreation: true},
+			"no-prefix":  {SourceRepo: testRepo, SourceBranch: "main"},
+			"no-literal": {SourceRepo: testRepo, SourceBranch: "main", BranchTemplate: "{{.Key}}"},
+		},
+	}
+
+	// The branches that entries commit straight to are never PR branches.
+	sourceBranches := []string{"gitops-live", "main", "release"}
+	want := []Target{
+		{Keys: []string{"no-literal", "no-prefix"}, Repo: testRepo, SourceBranches: sourceBranches},
+		{Keys: []string{"service-a", "service-b"}, Repo: testRepo, Prefix: "gitops-", SourceBranches: sourceBranches},
+		{Keys: []string{"internal"}, Connection: "internal", Repo: testRepo, Prefix: "gitops-", SourceBranches: sourceBranches},
+		{Keys: []string{"service-c"}, Repo: testRepo, Prefix: "gitops/", SourceBranches: sourceBranches},
+	}
+	if diff := cmp.Diff(want, Targets(configs)); diff != "" {
+		t.Errorf("Targets() failed diff\n%s", diff)
+	}
+}
+
+func TestProtectSourceBranches(t *testing.T) {
+	selected := &config.RepoConfiguration{
+		Repositories: map[string]*config.Repository{
+			"service-a": {SourceRepo: testRepo, SourceBranch: "main", BranchGenerateName: "gitops-"},
+		},
+	}
+	all := selected.Clone()
+	all.Repositories["direct"] = &config.Repository{SourceRepo: testRepo, SourceBranch: "gitops-live", DisablePRCreation: true}
+
+	want := []Target{{Keys: []string{"service-a"}, Repo: testRepo, Prefix: "gitops-", SourceBranches: []string{"gitops-live", "main"}}}
+	if diff := cmp.Diff(want, ProtectSourceBranches(Targets(selected), all)); diff != "" {
+		t.Errorf("ProtectSourceBranches() failed diff\n%s", diff)
+	}
+}
+
+func TestFormatAge(t *testing.T) {
+	ageTests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{90 * time.Second, "1m"},
+		{90 * time.Minute, "90m"},
+		{30 * time.Hour, "30h"},
+		{73 * time.Hour, "3d"},
+	}
+
+	for _, tt := range ageTests {
+		if got := FormatAge(tt.d); got != tt.want {
+			t.Errorf("FormatAge(%s) got %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+// fakeService is a Service with fixed branches, pull requests and commits,
+// recording the deleted branches.
+type fakeService struct {
+	branches  []*scm.Reference
+	prs       []*scm.PullRequest
+	commits   map[string]*scm.Commit
+	statuses  map[string][]*scm.Status
+	checkRuns map[string][]*scm.Status
+	deleteErr error
+	deleted   []string
+	closed    []int
+}
+
+func (f *fakeService) ListBranches(ctx context.Context, repo string) ([]*scm.Reference, error) {
+	return f.branches, nil
+}
+
+func (f *fakeService) ListPullRequests(ctx context.Context, repo string) ([]*scm.PullRequest, error) {
+	return f.prs, nil
+}
+
+func (f *fakeService) FindCommit(ctx context.Context, repo, ref string) (*scm.Commit, error) {
+	c, ok := f.commits[ref]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return c, nil
+}
+
+func (f *fakeService) ListStatuses(ctx context.Context, repo, ref string) ([]*scm.Status, error) {
+	return f.statuses[ref], nil
+}
+
+func (f *fakeService) ListCheckRuns(ctx context.Context, repo, ref string) ([]*scm.Status, error) {
+	return f.checkRuns[ref], nil
+}
+
+func (f *fakeService) ClosePullRequest(ctx context.Context, repo string, number int) error {
+	if f.deleteErr != nil {
+		return f.deleteErr
+	}
+	f.closed = append(f.closed, number)
+	return nil
+}
+
+func (f *fakeService) DeleteBranch(ctx context.Context, repo, branch string) error {
+	if f.deleteErr != nil {
+		return f.deleteErr
+	}
+	f.deleted = append(f.deleted, branch)
+	return nil
+}